@@ -0,0 +1,43 @@
+package gmikit
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+// MediaType parses the MIME type and parameters out of a successful
+// response's Meta.
+func (r *Response) MediaType() (string, map[string]string, error) {
+	return mime.ParseMediaType(r.Meta)
+}
+
+// DecodedBody returns the body of a text response converted to UTF-8, using
+// the charset parameter of its media type. Text responses without a charset
+// are UTF-8, as the specification says.
+func (r *Response) DecodedBody() (io.Reader, error) {
+	m, params, err := r.MediaType()
+	if err != nil {
+		return nil, err
+	}
+
+	charset, ok := params["charset"]
+	if !ok || !strings.HasPrefix(m, "text/") {
+		charset = "utf-8"
+	}
+	return NewUTF8Reader(r.Body, charset)
+}
+
+// NewUTF8Reader converts text in the named charset to UTF-8. Byte sequences
+// that aren't valid in the charset are replaced with U+FFFD.
+func NewUTF8Reader(r io.Reader, charset string) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset \"%s\": %w", charset, err)
+	}
+	return transform.NewReader(r, enc.NewDecoder()), nil
+}
@@ -0,0 +1,50 @@
+package gmikit
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDecodedBody(t *testing.T) {
+	tests := []struct {
+		meta     string
+		body     string
+		expected string
+	}{
+		{"text/gemini", "caf\xc3\xa9 \xff", "café �"},
+		{"text/gemini; charset=iso-8859-1", "caf\xe9", "café"},
+		{"text/gemini; charset=KOI8-R", "\xf0\xd2\xc9\xd7\xc5\xd4", "Привет"},
+	}
+
+	for _, test := range tests {
+		resp := &Response{
+			Status: StatusSuccess,
+			Meta:   test.meta,
+			Body:   strings.NewReader(test.body),
+		}
+		body, err := resp.DecodedBody()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Error(err)
+		}
+		if test.expected != string(actual) {
+			t.Errorf("Expected %q got %q", test.expected, actual)
+		}
+	}
+}
+
+func TestDecodedBodyUnknownCharset(t *testing.T) {
+	resp := &Response{
+		Status: StatusSuccess,
+		Meta:   "text/gemini; charset=x-nonsense",
+		Body:   strings.NewReader(""),
+	}
+	if _, err := resp.DecodedBody(); err == nil {
+		t.Error("Expected error for unknown charset")
+	}
+}
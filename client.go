@@ -19,8 +19,21 @@ var (
 	ErrInvalidStatus   = errors.New("gemini: invalid status")
 	ErrMetaTooLong     = errors.New("gemini: meta too long")
 	ErrMalformedHeader = errors.New("gemini: malformed header")
+
+	ErrTooManyRedirects = errors.New("gemini: too many redirects")
+	ErrRedirectLoop     = errors.New("gemini: redirect loop")
+	ErrRedirectScheme   = errors.New("gemini: redirect to another scheme")
+	ErrRedirectRefused  = errors.New("gemini: redirect refused")
+
+	// ErrUseLastResponse can be returned by a Client's CheckRedirect to stop
+	// following redirects, and return the redirect response itself.
+	ErrUseLastResponse = errors.New("gemini: use last response")
 )
 
+// DefaultMaxRedirects is how many redirects a Client follows, unless told
+// otherwise. It is the limit suggested by the specification.
+const DefaultMaxRedirects = 5
+
 var crlf = []byte("\r\n")
 
 type Status int
@@ -136,12 +149,17 @@ func (r *Request) Write(w *bufio.Writer) error {
 	return nil
 }
 
+// A Response is what a server answered. Request is the request it answered,
+// which is the last of any redirects followed, and Redirects are the URLs
+// which redirected to it, in the order they were requested.
 type Response struct {
-	Status Status
-	Meta   string
-	Body   io.Reader
-	TLS    tls.ConnectionState
-	closer io.Closer
+	Status    Status
+	Meta      string
+	Body      io.Reader
+	TLS       tls.ConnectionState
+	Request   *Request
+	Redirects []*url.URL
+	closer    io.Closer
 }
 
 func ReadResponse(rc io.ReadCloser) (*Response, error) {
@@ -206,31 +224,169 @@ func (r *Response) Close() error {
 	return nil
 }
 
+// Verification is how a Client decides whether to trust a server.
+type Verification int
+
+const (
+	// VerifyTrustOnFirstUse leaves it to the Client's TrustCertificate,
+	// which is usually backed by a KnownHosts store.
+	VerifyTrustOnFirstUse Verification = iota
+	// VerifyCertificateAuthority requires a chain to a trusted certificate
+	// authority which is valid for the hostname, like a web browser.
+	VerifyCertificateAuthority
+	// VerifyEither trusts certificates from a certificate authority, and
+	// falls back to TrustCertificate for any others.
+	VerifyEither
+)
+
+// A Client makes Gemini requests. Certificate, if set, chooses the client
+// certificate for requests which don't have one, and is usually backed by an
+// IdentityStore.
+//
+// Redirects are followed up to MaxRedirects, or DefaultMaxRedirects if that
+// is zero, but never to another scheme or to a URL already visited. If
+// MaxRedirects is negative, the first redirect response is returned as is.
+// ConfirmRedirect, if set, is asked whether to follow a redirect to another
+// host. CheckRedirect, if set, is called last, only for redirects that pass
+// those checks, with the request to be made and the requests made so far,
+// oldest first. It can return ErrUseLastResponse to get the redirect response
+// instead, or any other error to fail.
+//
+// TLSConfig, if set, is the starting point for
+// each connection, which can be used to set things like the minimum version,
+// cipher suites, a session cache or the root certificate authorities. Its
+// verification, server name and client certificate settings are replaced.
 type Client struct {
 	TrustCertificate func(hostname string, cert *x509.Certificate) error
+	Certificate      func(u *url.URL) *tls.Certificate
 	Timeout          time.Duration
+	Verify           Verification
+	TLSConfig        *tls.Config
+	MaxRedirects     int
+	CheckRedirect    func(req *Request, via []*Request) error
+	ConfirmRedirect  func(req *Request, via []*Request) bool
 }
 
+// Do sends a request, and follows any redirects.
 func (c *Client) Do(req *Request) (*Response, error) {
-	config := &tls.Config{
-		InsecureSkipVerify: true,
-		MinVersion:         tls.VersionTLS12,
-		GetClientCertificate: func(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
-			if req.Certificate != nil {
-				return req.Certificate, nil
-			} else {
-				return &tls.Certificate{}, nil
-			}
-		},
-		VerifyConnection: func(cs tls.ConnectionState) error {
-			if c.TrustCertificate != nil {
-				cert := cs.PeerCertificates[0]
-				return c.TrustCertificate(req.URL.Host, cert)
-			} else {
-				return nil
+	var via []*Request
+	for {
+		resp, err := c.roundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Request = req
+		for _, r := range via {
+			resp.Redirects = append(resp.Redirects, r.URL)
+		}
+		if resp.Status.Class() != StatusClassRedirect {
+			return resp, nil
+		}
+
+		via = append(via, req)
+		req, err = c.redirect(resp, via)
+		if errors.Is(err, ErrUseLastResponse) {
+			return resp, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// redirect makes the request for a redirect response, if it should be
+// followed.
+func (c *Client) redirect(resp *Response, via []*Request) (*Request, error) {
+	last := via[len(via)-1]
+	target, err := url.Parse(resp.Meta)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	target = last.URL.ResolveReference(target)
+
+	req := NewRequest(target)
+	req.Context = last.Context
+	sameHost := knownHostKey(target.Host) == knownHostKey(last.URL.Host)
+	if sameHost {
+		// Keep where we connect to and who we are, which aren't sent to
+		// anyone else
+		req.Host = last.Host
+		req.Certificate = last.Certificate
+	}
+
+	max := c.MaxRedirects
+	if max == 0 {
+		max = DefaultMaxRedirects
+	} else if max < 0 {
+		return nil, ErrUseLastResponse
+	}
+	if len(via) > max {
+		return nil, ErrTooManyRedirects
+	}
+	if target.Scheme != last.URL.Scheme {
+		return nil, fmt.Errorf("%w: %s", ErrRedirectScheme, target)
+	}
+	for _, r := range via {
+		if r.URL.String() == target.String() {
+			return nil, fmt.Errorf("%w: %s", ErrRedirectLoop, target)
+		}
+	}
+	if !sameHost && c.ConfirmRedirect != nil && !c.ConfirmRedirect(req, via) {
+		return nil, fmt.Errorf("%w: %s", ErrRedirectRefused, target)
+	}
+	if c.CheckRedirect != nil {
+		if err := c.CheckRedirect(req, via); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// roundTrip sends a single request.
+func (c *Client) roundTrip(req *Request) (*Response, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.TLSConfig != nil {
+		config = c.TLSConfig.Clone()
+		if config.MinVersion == 0 {
+			config.MinVersion = tls.VersionTLS12
+		}
+	}
+
+	// Servers are sent the bare hostname, and nothing at all for an IP
+	// address, as SNI requires
+	hostname := req.URL.Hostname()
+	if net.ParseIP(hostname) == nil {
+		config.ServerName = hostname
+	} else {
+		config.ServerName = ""
+	}
+
+	// Verification is done by VerifyConnection, since most Gemini servers
+	// use self-signed certificates
+	config.InsecureSkipVerify = true
+	cert := req.Certificate
+	if cert == nil && c.Certificate != nil {
+		cert = c.Certificate(req.URL)
+	}
+	config.GetClientCertificate = func(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		if cert != nil {
+			return cert, nil
+		} else {
+			return &tls.Certificate{}, nil
+		}
+	}
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		if c.Verify != VerifyTrustOnFirstUse {
+			err := verifyChain(cs.PeerCertificates, hostname, config.RootCAs)
+			if err == nil || c.Verify == VerifyCertificateAuthority {
+				return err
 			}
-		},
-		ServerName: req.URL.Host,
+		}
+		if c.TrustCertificate != nil {
+			cert := cs.PeerCertificates[0]
+			return c.TrustCertificate(req.URL.Host, cert)
+		} else {
+			return nil
+		}
 	}
 
 	ctx := req.Context
@@ -267,6 +423,21 @@ func (c *Client) Do(req *Request) (*Response, error) {
 	return resp, nil
 }
 
+// verifyChain checks that certs chain to one of roots, or the system roots if
+// that is nil, and are valid for hostname.
+func verifyChain(certs []*x509.Certificate, hostname string, roots *x509.CertPool) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       hostname,
+	})
+	return err
+}
+
 func (c *Client) do(conn *tls.Conn, req *Request) (*Response, error) {
 	w := bufio.NewWriter(conn)
 	err := req.Write(w)
@@ -0,0 +1,318 @@
+package gmikit
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testServer is a Gemini server on a local port, which answers every request
+// with the response returned by handle.
+type testServer struct {
+	listener    net.Listener
+	serverNames chan string
+}
+
+func newTestServer(t *testing.T, cert tls.Certificate, handle func(req string, state tls.ConnectionState) string) *testServer {
+	s := &testServer{serverNames: make(chan string, 10)}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequestClientCert,
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			select {
+			case s.serverNames <- hello.ServerName:
+			default:
+			}
+			return nil, nil
+		},
+	}
+	var err error
+	s.listener, err = tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil {
+					return
+				}
+				state := conn.(*tls.Conn).ConnectionState()
+				fmt.Fprint(conn, handle(strings.TrimSuffix(req, "\r\n"), state))
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *testServer) Close() {
+	s.listener.Close()
+}
+
+func (s *testServer) Port() string {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return port
+}
+
+// request makes a request for a URL on the server with the given host,
+// always connecting to the server itself.
+func (s *testServer) request(t *testing.T, host string) *Request {
+	target, err := url.Parse("gemini://" + net.JoinHostPort(host, s.Port()) + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := NewRequest(target)
+	req.Host = s.listener.Addr().String()
+	return req
+}
+
+// testAuthority makes a certificate authority, and a server certificate
+// signed by it for localhost.
+func testAuthority(t *testing.T) (*x509.CertPool, tls.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	return roots, tls.Certificate{Certificate: [][]byte{der, caDer}, PrivateKey: key}
+}
+
+func testGet(client *Client, req *Request) (string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Close()
+	if resp.Body == nil {
+		return resp.Status.String(), nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestClientServerName(t *testing.T) {
+	_, cert := testAuthority(t)
+	server := newTestServer(t, cert, func(string, tls.ConnectionState) string {
+		return "20 text/gemini\r\nHello\n"
+	})
+	defer server.Close()
+
+	client := &Client{}
+	for host, expected := range map[string]string{
+		"localhost": "localhost",
+		"127.0.0.1": "",
+	} {
+		body, err := testGet(client, server.request(t, host))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != "Hello\n" {
+			t.Errorf("Expected Hello got %v", body)
+		}
+		if actual := <-server.serverNames; actual != expected {
+			t.Errorf("Expected server name %q for %s got %q", expected, host, actual)
+		}
+	}
+}
+
+func TestClientVerify(t *testing.T) {
+	roots, cert := testAuthority(t)
+	server := newTestServer(t, cert, func(string, tls.ConnectionState) string {
+		return "20 text/gemini\r\nHello\n"
+	})
+	defer server.Close()
+
+	trusted := 0
+	client := &Client{
+		TrustCertificate: func(hostname string, cert *x509.Certificate) error {
+			trusted++
+			return nil
+		},
+		Verify:    VerifyCertificateAuthority,
+		TLSConfig: &tls.Config{RootCAs: roots},
+	}
+	if _, err := testGet(client, server.request(t, "localhost")); err != nil {
+		t.Errorf("Expected certificate authority to be trusted, got %v", err)
+	}
+	if _, err := testGet(client, server.request(t, "127.0.0.1")); err == nil {
+		t.Error("Expected wrong hostname to be rejected")
+	}
+
+	client.TLSConfig.RootCAs = x509.NewCertPool()
+	if _, err := testGet(client, server.request(t, "localhost")); err == nil {
+		t.Error("Expected unknown certificate authority to be rejected")
+	}
+	if trusted != 0 {
+		t.Errorf("Expected TrustCertificate not to be called, called %d times", trusted)
+	}
+
+	client.Verify = VerifyEither
+	if _, err := testGet(client, server.request(t, "localhost")); err != nil {
+		t.Errorf("Expected fallback to TrustCertificate, got %v", err)
+	}
+	if trusted != 1 {
+		t.Errorf("Expected TrustCertificate to be called once, called %d times", trusted)
+	}
+}
+
+func TestClientRedirect(t *testing.T) {
+	_, cert := testAuthority(t)
+	var port string
+	server := newTestServer(t, cert, func(req string, _ tls.ConnectionState) string {
+		u, err := url.Parse(req)
+		if err != nil {
+			return "59 Bad request\r\n"
+		}
+		switch {
+		case u.Path == "/a":
+			return "30 b\r\n"
+		case u.Path == "/b":
+			return "31 /c\r\n"
+		case u.Path == "/c":
+			return "20 text/plain\r\nC"
+		case u.Path == "/loop":
+			return "30 /a/../loop\r\n"
+		case u.Path == "/scheme":
+			return "30 https://localhost/\r\n"
+		case u.Path == "/host":
+			return "30 gemini://127.0.0.1:" + port + "/c\r\n"
+		case strings.HasPrefix(u.Path, "/n"):
+			return "30 " + u.Path + "n\r\n"
+		}
+		return "51 Not found\r\n"
+	})
+	defer server.Close()
+	port = server.Port()
+
+	get := func(client *Client, path string) (*Response, error) {
+		req := server.request(t, "localhost")
+		req.URL.Path = path
+		return client.Do(req)
+	}
+
+	resp, err := get(&Client{}, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Close()
+	if resp.Status != StatusSuccess || resp.Request.URL.Path != "/c" {
+		t.Errorf("Expected success at /c got %v at %v", resp.Status, resp.Request.URL)
+	}
+	if len(resp.Redirects) != 2 || resp.Redirects[0].Path != "/a" || resp.Redirects[1].Path != "/b" {
+		t.Errorf("Expected redirects from /a and /b got %v", resp.Redirects)
+	}
+
+	for path, expected := range map[string]error{
+		"/loop":   ErrRedirectLoop,
+		"/scheme": ErrRedirectScheme,
+		"/n":      ErrTooManyRedirects,
+	} {
+		if _, err := get(&Client{}, path); !errors.Is(err, expected) {
+			t.Errorf("Expected %v for %s got %v", expected, path, err)
+		}
+	}
+
+	requests := 0
+	client := &Client{MaxRedirects: 2, CheckRedirect: func(req *Request, via []*Request) error {
+		requests = len(via)
+		return nil
+	}}
+	if _, err := get(client, "/n"); !errors.Is(err, ErrTooManyRedirects) || requests != 2 {
+		t.Errorf("Expected too many redirects after 2 followed got %v after %d", err, requests)
+	}
+
+	checked := 0
+	client = &Client{CheckRedirect: func(*Request, []*Request) error {
+		checked++
+		return nil
+	}}
+	for _, path := range []string{"/loop", "/scheme"} {
+		if _, err := get(client, path); err == nil {
+			t.Errorf("Expected error for %s", path)
+		}
+	}
+	client.ConfirmRedirect = func(*Request, []*Request) bool { return false }
+	if _, err := get(client, "/host"); !errors.Is(err, ErrRedirectRefused) {
+		t.Errorf("Expected refused redirect got %v", err)
+	}
+	if checked != 0 {
+		t.Errorf("Expected CheckRedirect not to be called, called %d times", checked)
+	}
+
+	client = &Client{MaxRedirects: -1}
+	if resp, err := get(client, "/scheme"); err != nil {
+		t.Error(err)
+	} else if resp.Status != StatusRedirect || resp.Meta != "https://localhost/" {
+		t.Errorf("Expected redirect response got %v %v", resp.Status, resp.Meta)
+	}
+
+	confirmed := false
+	client = &Client{ConfirmRedirect: func(req *Request, via []*Request) bool {
+		return confirmed
+	}}
+	if _, err := get(client, "/host"); !errors.Is(err, ErrRedirectRefused) {
+		t.Errorf("Expected refused redirect got %v", err)
+	}
+	confirmed = true
+	if resp, err := get(client, "/host"); err != nil {
+		t.Error(err)
+	} else if resp.Status != StatusSuccess || resp.Request.URL.Host != "127.0.0.1:"+port {
+		t.Errorf("Expected success at 127.0.0.1 got %v at %v", resp.Status, resp.Request.URL)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	tt "text/template"
+
+	"anachronauts.club/repos/gmikit"
+	flag "github.com/spf13/pflag"
+)
+
+var base *string = flag.StringP("base", "b", "", "URL the capsule is served at, needed for Atom feeds")
+var title *string = flag.StringP("title", "T", "", "Title of the capsule's home page")
+var templateDir *string = flag.StringP("templates", "t", "", "Directory of templates to use instead of the defaults")
+var htmlDir *string = flag.String("html", "", "Also write an HTML mirror to this directory")
+var site *string = flag.String("site", "", "Name used to pick the HTML mirror's colors")
+
+func fatal(v ...interface{}) {
+	fmt.Fprintln(os.Stderr, v...)
+	os.Exit(1)
+}
+
+type builder struct {
+	site    *Site
+	src     string
+	dst     string
+	base    *url.URL
+	gmi     *tt.Template
+	written []string
+}
+
+func (b *builder) create(rel string) (*os.File, error) {
+	name := filepath.Join(b.dst, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return nil, err
+	}
+	b.written = append(b.written, rel)
+	return os.Create(name)
+}
+
+func (b *builder) copy(rel string) error {
+	r, err := os.Open(filepath.Join(b.src, filepath.FromSlash(rel)))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := b.create(rel)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (b *builder) execute(rel string, data interface{}) error {
+	w, err := b.create(rel)
+	if err != nil {
+		return err
+	}
+	if err := b.gmi.ExecuteTemplate(w, "index.gmi", data); err != nil {
+		w.Close()
+		return fmt.Errorf("%s: %w", rel, err)
+	}
+	return w.Close()
+}
+
+// index writes the index page of a directory, if it doesn't have one, and an
+// Atom feed for directories of dated pages.
+func (b *builder) index(dir *Dir) error {
+	indexPath := path.Join(dir.Path, "index.gmi")
+	feedPath := path.Join(dir.Path, "atom.xml")
+	feed := b.base != nil && dir.Dated()
+
+	if !dir.HasIndex {
+		name := path.Base(dir.Path)
+		if dir.Path == "" {
+			name = *title
+			if name == "" && b.base != nil {
+				name = b.base.Hostname()
+			}
+		}
+		if err := b.execute(indexPath, dirIndex(dir, name, feed)); err != nil {
+			return err
+		}
+	}
+
+	if !feed {
+		return nil
+	}
+
+	// The feed is whatever the index page says, generated or not
+	r, err := os.Open(filepath.Join(b.dst, filepath.FromSlash(indexPath)))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	dirURL := b.base.ResolveReference(&url.URL{Path: dir.Path + "/"})
+	if dir.Path == "" {
+		dirURL = b.base
+	}
+	parsed, err := gmikit.ParseFeed(r, dirURL)
+	if err != nil {
+		return fmt.Errorf("%s: %w", indexPath, err)
+	}
+	if len(parsed.Entries) == 0 {
+		return nil
+	}
+
+	w, err := b.create(feedPath)
+	if err != nil {
+		return err
+	}
+	if err := parsed.WriteAtom(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// tags writes a page for each tag, and an index of them all.
+func (b *builder) tags() error {
+	if len(b.site.Tags) == 0 {
+		return nil
+	}
+
+	all, pages := tagIndexes(b.site.Tags)
+	var names []string
+	for name := range pages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := b.execute(path.Join("tags", name), pages[name]); err != nil {
+			return err
+		}
+	}
+	return b.execute(path.Join("tags", "index.gmi"), all)
+}
+
+func (b *builder) build() error {
+	for _, rel := range b.site.Files {
+		if err := b.copy(rel); err != nil {
+			return err
+		}
+	}
+
+	var dirs []string
+	for dir := range b.site.Dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		if err := b.index(b.site.Dirs[dir]); err != nil {
+			return err
+		}
+	}
+
+	return b.tags()
+}
+
+func loadGmiTemplates(dir string) (*tt.Template, error) {
+	t, err := tt.New("gmi").Parse(defaultGmiTemplates)
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return t, nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.gmi"))
+	if err != nil || len(files) == 0 {
+		return t, err
+	}
+	return t.ParseFiles(files...)
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 2 {
+		fatal(fmt.Sprintf("usage: %s [options] source destination", os.Args[0]))
+	}
+
+	src, err := filepath.Abs(flag.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	dst, err := filepath.Abs(flag.Arg(1))
+	if err != nil {
+		fatal(err)
+	}
+
+	b := &builder{src: src, dst: dst}
+	if *base != "" {
+		if b.base, err = url.Parse(*base); err != nil {
+			fatal(err)
+		}
+		if !strings.HasSuffix(b.base.Path, "/") {
+			b.base.Path += "/"
+		}
+	}
+
+	// The output might be inside the source, and so might the templates
+	skip := []string{dst}
+	for _, dir := range []string{*templateDir, *htmlDir} {
+		if dir == "" {
+			continue
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			fatal(err)
+		}
+		skip = append(skip, abs)
+	}
+	if b.gmi, err = loadGmiTemplates(*templateDir); err != nil {
+		fatal(err)
+	}
+
+	if b.site, err = ScanSite(src, skip...); err != nil {
+		fatal(err)
+	}
+	if err := b.build(); err != nil {
+		fatal(err)
+	}
+
+	if *htmlDir != "" {
+		name := *site
+		if name == "" && b.base != nil {
+			name = b.base.Hostname()
+		}
+		if err := writeMirror(dst, *htmlDir, b.written, name, *templateDir); err != nil {
+			fatal(err)
+		}
+	}
+}
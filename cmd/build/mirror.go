@@ -0,0 +1,153 @@
+package main
+
+import (
+	ht "html/template"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"anachronauts.club/repos/gmikit"
+	"anachronauts.club/repos/gmikit/cmd/gateway/theme"
+)
+
+type Style struct {
+	Light *theme.Theme
+	Dark  *theme.Theme
+}
+
+// HtmlPage is what the page.html template is given.
+type HtmlPage struct {
+	Title   string
+	Site    string
+	Style   *Style
+	Outline *gmikit.Outline
+	Body    ht.HTML
+}
+
+func loadHtmlTemplates(dir string) (*ht.Template, error) {
+	t, err := ht.New("html").Funcs(ht.FuncMap{
+		"safeURL": func(url *url.URL) ht.URL {
+			return ht.URL(url.String())
+		},
+	}).Parse(defaultHtmlTemplates)
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return t, nil
+	}
+	for _, pattern := range []string{"*.html", "*.css"} {
+		files, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			continue
+		}
+		if t, err = t.ParseFiles(files...); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// rewriteLocal points relative links to gemtext pages at their HTML versions.
+func rewriteLocal(target *url.URL) (*url.URL, string, error) {
+	if target.Scheme != "" || target.Host != "" {
+		return target, target.Scheme, nil
+	}
+	if path.Ext(target.Path) == ".gmi" {
+		rewritten := *target
+		rewritten.Path = strings.TrimSuffix(target.Path, ".gmi") + ".html"
+		target = &rewritten
+	}
+	return target, "local", nil
+}
+
+// writeMirror converts the gemtext pages written to dst into HTML pages in
+// htmlDir, and copies everything else as it is.
+func writeMirror(dst string, htmlDir string, files []string, site string, templateDir string) error {
+	t, err := loadHtmlTemplates(templateDir)
+	if err != nil {
+		return err
+	}
+	style := &Style{
+		Light: theme.NewWhiteTheme(site),
+		Dark:  theme.NewColorfulDarkTheme(site),
+	}
+
+	for _, rel := range files {
+		src := filepath.Join(dst, filepath.FromSlash(rel))
+		out := filepath.Join(htmlDir, filepath.FromSlash(rel))
+		if path.Ext(rel) == ".gmi" {
+			out = strings.TrimSuffix(out, ".gmi") + ".html"
+		}
+		if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+			return err
+		}
+
+		r, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		w, err := os.Create(out)
+		if err != nil {
+			r.Close()
+			return err
+		}
+
+		if path.Ext(rel) == ".gmi" {
+			err = writeHtmlPage(w, r, t, site, style)
+		} else {
+			_, err = io.Copy(w, r)
+		}
+		r.Close()
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeHtmlPage(w io.Writer, r io.Reader, t *ht.Template, site string, style *Style) error {
+	var body strings.Builder
+	html := gmikit.NewHtmlWriter(&body, rewriteLocal)
+	html.HeadingIDs = true
+	outline := gmikit.NewOutlineBuilder()
+
+	parser := gmikit.Parser{Lenient: true, LongLines: gmikit.LongLineSplit}
+	if err := parser.ParseLines(r, gmikit.Tee(outline, html)); err != nil {
+		return err
+	}
+
+	page := HtmlPage{
+		Site:    site,
+		Style:   style,
+		Outline: outline.Outline,
+		Body:    ht.HTML(body.String()),
+	}
+	page.Title = outlineTitle(page.Outline)
+	return t.ExecuteTemplate(w, "page.html", page)
+}
+
+// outlineTitle is the first of the highest level headings in an outline, the
+// same heading a page's title comes from when it is indexed.
+func outlineTitle(outline *gmikit.Outline) string {
+	var title *gmikit.OutlineHeading
+	// Headings nested under others can't be higher level than them
+	for _, h := range outline.Headings {
+		if title == nil || h.Level < title.Level {
+			title = h
+		}
+	}
+	if title == nil {
+		return ""
+	}
+	return title.Text
+}
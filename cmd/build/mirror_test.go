@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"anachronauts.club/repos/gmikit"
+	"anachronauts.club/repos/gmikit/cmd/gateway/theme"
+)
+
+func TestOutlineTitle(t *testing.T) {
+	tests := []struct {
+		input string
+		title string
+	}{
+		{"## Sub\n# Title\n# Another\n", "Title"},
+		{"### Three\n## Two\n### Three again\n", "Two"},
+		{"# First\n## Sub\n", "First"},
+		{"No headings\n", ""},
+	}
+
+	for _, test := range tests {
+		outline, err := gmikit.ParseOutline(strings.NewReader(test.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := outlineTitle(outline); actual != test.title {
+			t.Errorf("%q: expected %q got %q", test.input, test.title, actual)
+		}
+	}
+}
+
+func TestWriteHtmlPage(t *testing.T) {
+	input := "## Intro\n# Title\n## Part one\n=> other.gmi Other\n"
+
+	tmpl, err := loadHtmlTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	style := &Style{Light: theme.NewWhiteTheme("test"), Dark: theme.NewColorfulDarkTheme("test")}
+	var output strings.Builder
+	if err := writeHtmlPage(&output, strings.NewReader(input), tmpl, "test", style); err != nil {
+		t.Fatal(err)
+	}
+
+	actual := output.String()
+	for _, expected := range []string{
+		"<title>Title - test</title>",
+		"<nav>\n<ul>\n<li><a href=\"#intro\">Intro</a></li>\n" +
+			"<li><a href=\"#title\">Title</a>\n<ul>\n" +
+			"<li><a href=\"#part-one\">Part one</a></li>\n</ul></li>\n</ul>\n</nav>",
+		"<h2 id=\"part-one\">Part one</h2>",
+		"<a href=\"other.html\" class=\"local\">Other</a>",
+	} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("Expected %q in %s", expected, actual)
+		}
+	}
+}
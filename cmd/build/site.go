@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"anachronauts.club/repos/gmikit"
+)
+
+// A Page is a gemtext file in the source directory. Path is slash-separated
+// and relative to the root of the site.
+type Page struct {
+	Path  string
+	Title string
+	Date  time.Time
+	Tags  []string
+}
+
+func (p *Page) Dated() bool {
+	return !p.Date.IsZero()
+}
+
+// A Dir is a directory of the site. Path is "" for the root.
+type Dir struct {
+	Path     string
+	Dirs     []string
+	Pages    []*Page
+	HasIndex bool
+}
+
+func (d *Dir) Dated() bool {
+	for _, p := range d.Pages {
+		if p.Dated() {
+			return true
+		}
+	}
+	return false
+}
+
+type Site struct {
+	Dirs  map[string]*Dir
+	Pages []*Page
+	Files []string
+	Tags  map[string][]*Page
+}
+
+const dateLayout = "2006-01-02"
+
+// ScanSite finds everything in the source directory, skipping hidden files
+// and anything under the skipped paths.
+func ScanSite(src string, skip ...string) (*Site, error) {
+	site := &Site{
+		Dirs: make(map[string]*Dir),
+		Tags: make(map[string][]*Page),
+	}
+
+	err := filepath.Walk(src, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rel = ""
+		}
+
+		base := path.Base(rel)
+		if (rel != "" && strings.HasPrefix(base, ".")) || skipped(name, skip) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			site.Dirs[rel] = &Dir{Path: rel}
+			if rel != "" {
+				parent := site.Dirs[parentDir(rel)]
+				parent.Dirs = append(parent.Dirs, base)
+			}
+			return nil
+		}
+
+		site.Files = append(site.Files, rel)
+		if path.Ext(rel) != ".gmi" {
+			return nil
+		}
+
+		page, err := readPage(name, rel)
+		if err != nil {
+			return err
+		}
+		dir := site.Dirs[parentDir(rel)]
+		if base == "index.gmi" {
+			dir.HasIndex = true
+			return nil
+		}
+		dir.Pages = append(dir.Pages, page)
+		site.Pages = append(site.Pages, page)
+		for _, tag := range page.Tags {
+			site.Tags[tag] = append(site.Tags[tag], page)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return site, nil
+}
+
+func skipped(name string, skip []string) bool {
+	for _, s := range skip {
+		if name == s {
+			return true
+		}
+	}
+	return false
+}
+
+func parentDir(rel string) string {
+	dir := path.Dir(rel)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// readPage reads the title, date and tags of the page at name, whose path
+// within the site is rel.
+func readPage(name string, rel string) (*Page, error) {
+	r, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return parsePage(r, rel)
+}
+
+// parsePage reads the title, date and tags of a page. The title is the first
+// of the highest level headings, or else the file name. Dates come from a
+// YYYY-MM-DD prefix on the file name, and tags from a text line like
+// "Tags: gemini, go".
+func parsePage(r io.Reader, rel string) (*Page, error) {
+	parser := gmikit.Parser{Lenient: true, LongLines: gmikit.LongLineSplit}
+	doc, err := parser.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Path: rel}
+	var base string
+	page.Date, base = pageDate(strings.TrimSuffix(path.Base(rel), ".gmi"))
+
+	level := 4
+	for _, line := range doc.Lines {
+		switch line := line.(type) {
+		case gmikit.HeadingLine:
+			if line.Level < level {
+				page.Title = line.Text
+				level = line.Level
+			}
+		case gmikit.TextLine:
+			page.Tags = append(page.Tags, pageTags(line.Text)...)
+		}
+	}
+	if page.Title == "" {
+		page.Title = base
+	}
+	return page, nil
+}
+
+// pageDate splits a YYYY-MM-DD prefix off a file name, returning the date and
+// the rest of the name. Names without a date are returned as they are.
+func pageDate(base string) (time.Time, string) {
+	if len(base) < len(dateLayout) {
+		return time.Time{}, base
+	}
+	date, err := time.Parse(dateLayout, base[:len(dateLayout)])
+	if err != nil {
+		return time.Time{}, base
+	}
+	return date, strings.TrimLeft(base[len(dateLayout):], "-_ ")
+}
+
+// pageTags finds the tags in a line like "Tags: gemini, go", lower-cased.
+// Any other line has none.
+func pageTags(text string) []string {
+	if len(text) <= 5 || !strings.EqualFold(text[:5], "tags:") {
+		return nil
+	}
+	var tags []string
+	for _, tag := range strings.Split(text[5:], ",") {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// A Link is a line in a generated index page.
+type Link struct {
+	Href  string
+	Title string
+	Date  string
+}
+
+// Index is what an index page template is given.
+type Index struct {
+	Title   string
+	Feed    string
+	Dirs    []Link
+	Entries []Link
+	Pages   []Link
+}
+
+// linkPath escapes a slash-separated path so it can be the target of a link
+// line, which ends at the first space.
+func linkPath(p string) string {
+	return (&url.URL{Path: p}).String()
+}
+
+// links sorts pages into dated entries, newest first, and other pages by
+// title. Pages in the directory from are linked to by name, and prefix goes
+// in front of every link.
+func links(pages []*Page, prefix string, from string) ([]Link, []Link) {
+	sorted := make([]*Page, len(pages))
+	copy(sorted, pages)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Date.Equal(sorted[j].Date) {
+			return sorted[i].Title < sorted[j].Title
+		}
+		return sorted[i].Date.After(sorted[j].Date)
+	})
+
+	var entries, others []Link
+	for _, p := range sorted {
+		href := p.Path
+		if from != "" {
+			href = strings.TrimPrefix(href, from+"/")
+		}
+		link := Link{Href: linkPath(prefix + href), Title: p.Title}
+		if p.Dated() {
+			link.Date = p.Date.Format(dateLayout)
+			entries = append(entries, link)
+		} else {
+			others = append(others, link)
+		}
+	}
+	return entries, others
+}
+
+// dirIndex is the generated index page of a directory, linking to its
+// subdirectories and pages. With feed set, it links to the directory's Atom
+// feed too.
+func dirIndex(dir *Dir, title string, feed bool) Index {
+	index := Index{Title: title}
+	if feed {
+		index.Feed = "atom.xml"
+	}
+
+	names := make([]string, len(dir.Dirs))
+	copy(names, dir.Dirs)
+	sort.Strings(names)
+	for _, name := range names {
+		index.Dirs = append(index.Dirs, Link{Href: linkPath(name + "/"), Title: name})
+	}
+	index.Entries, index.Pages = links(dir.Pages, "", dir.Path)
+	return index
+}
+
+// tagIndexes makes the index of all tags, and the page for each tag keyed by
+// its file name in the tags directory. Tags which slugify to the same name
+// are numbered apart, and none of them can take the index's name.
+func tagIndexes(tags map[string][]*Page) (Index, map[string]Index) {
+	var names []string
+	for tag := range tags {
+		names = append(names, tag)
+	}
+	sort.Strings(names)
+
+	var slugs gmikit.Slugger
+	slugs.Slug("index")
+
+	all := Index{Title: "Tags"}
+	pages := make(map[string]Index)
+	for _, tag := range names {
+		name := slugs.Slug(tag) + ".gmi"
+		all.Pages = append(all.Pages, Link{Href: name, Title: tag})
+
+		index := Index{Title: fmt.Sprintf("Pages tagged \"%s\"", tag)}
+		index.Entries, index.Pages = links(tags[tag], "../", "")
+		pages[name] = index
+	}
+	return all, pages
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"anachronauts.club/repos/gmikit"
+)
+
+func writeFixture(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestScanSite(t *testing.T) {
+	src := writeFixture(t, map[string]string{
+		"index.gmi":                      "# Home\n",
+		"about.gmi":                      "## Sub\n# About\n",
+		"image.png":                      "png",
+		".hidden/secret.gmi":             "# Secret\n",
+		"out/index.gmi":                  "# Output\n",
+		"gemlog/2021-03-04-hello.gmi":    "# Hello\nTags: Gemini, go\n",
+		"gemlog/2021-05-06_untitled.gmi": "Just text\ntags: go\n",
+	})
+
+	site, err := ScanSite(src, filepath.Join(src, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := []string{
+		"about.gmi",
+		"gemlog/2021-03-04-hello.gmi",
+		"gemlog/2021-05-06_untitled.gmi",
+		"image.png",
+		"index.gmi",
+	}
+	if !reflect.DeepEqual(site.Files, files) {
+		t.Errorf("Expected files %v got %v", files, site.Files)
+	}
+
+	root := site.Dirs[""]
+	if root == nil || !root.HasIndex || !reflect.DeepEqual(root.Dirs, []string{"gemlog"}) {
+		t.Fatalf("Unexpected root %+v", root)
+	}
+	if root.Dated() {
+		t.Error("Expected root to be undated")
+	}
+	if len(root.Pages) != 1 || root.Pages[0].Title != "About" {
+		t.Errorf("Expected just the About page in root got %+v", root.Pages)
+	}
+
+	gemlog := site.Dirs["gemlog"]
+	if gemlog == nil || gemlog.HasIndex || !gemlog.Dated() {
+		t.Fatalf("Unexpected gemlog %+v", gemlog)
+	}
+	var titles []string
+	for _, p := range gemlog.Pages {
+		titles = append(titles, p.Title)
+	}
+	if !reflect.DeepEqual(titles, []string{"Hello", "untitled"}) {
+		t.Errorf("Expected gemlog titles Hello, untitled got %v", titles)
+	}
+
+	if len(site.Dirs) != 2 {
+		t.Errorf("Expected 2 directories got %d", len(site.Dirs))
+	}
+	if len(site.Tags["gemini"]) != 1 || len(site.Tags["go"]) != 2 {
+		t.Errorf("Unexpected tags %v", site.Tags)
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	input := "### Three\n## Two\n# One\n# Another\nTags: a, B,, c \n"
+	page, err := parsePage(strings.NewReader(input), "dir/2020-01-02 page.gmi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Page{
+		Path:  "dir/2020-01-02 page.gmi",
+		Title: "One",
+		Date:  time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+		Tags:  []string{"a", "b", "c"},
+	}
+	if !reflect.DeepEqual(page, expected) {
+		t.Errorf("Expected %+v got %+v", expected, page)
+	}
+}
+
+func TestPageDate(t *testing.T) {
+	tests := []struct {
+		base string
+		date string
+		rest string
+	}{
+		{"2021-03-04-hello", "2021-03-04", "hello"},
+		{"2021-03-04_hello", "2021-03-04", "hello"},
+		{"2021-03-04 hello", "2021-03-04", "hello"},
+		{"2021-03-04", "2021-03-04", ""},
+		{"2021-13-04-bad", "", "2021-13-04-bad"},
+		{"hello", "", "hello"},
+		{"", "", ""},
+	}
+
+	for _, test := range tests {
+		date, rest := pageDate(test.base)
+		actual := ""
+		if !date.IsZero() {
+			actual = date.Format(dateLayout)
+		}
+		if actual != test.date || rest != test.rest {
+			t.Errorf("%q: expected %q %q got %q %q",
+				test.base, test.date, test.rest, actual, rest)
+		}
+	}
+}
+
+func TestPageTags(t *testing.T) {
+	tests := []struct {
+		text string
+		tags []string
+	}{
+		{"Tags: gemini, go", []string{"gemini", "go"}},
+		{"tags:Go", []string{"go"}},
+		{"TAGS: a,, b ,", []string{"a", "b"}},
+		{"Tags:", nil},
+		{"Tags: ", nil},
+		{"Some tags: here", nil},
+		{"", nil},
+	}
+
+	for _, test := range tests {
+		actual := pageTags(test.text)
+		if !reflect.DeepEqual(actual, test.tags) {
+			t.Errorf("%q: expected %v got %v", test.text, test.tags, actual)
+		}
+	}
+}
+
+func TestDirIndex(t *testing.T) {
+	dir := &Dir{
+		Path: "gemlog",
+		Dirs: []string{"zebra", "apple"},
+		Pages: []*Page{
+			{Path: "gemlog/b.gmi", Title: "B"},
+			{Path: "gemlog/old.gmi", Title: "Old", Date: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+			{Path: "gemlog/a.gmi", Title: "A"},
+			{Path: "gemlog/new.gmi", Title: "New", Date: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	expected := Index{
+		Title: "Gemlog",
+		Feed:  "atom.xml",
+		Dirs: []Link{
+			{Href: "apple/", Title: "apple"},
+			{Href: "zebra/", Title: "zebra"},
+		},
+		Entries: []Link{
+			{Href: "new.gmi", Title: "New", Date: "2021-01-01"},
+			{Href: "old.gmi", Title: "Old", Date: "2020-01-01"},
+		},
+		Pages: []Link{
+			{Href: "a.gmi", Title: "A"},
+			{Href: "b.gmi", Title: "B"},
+		},
+	}
+
+	actual := dirIndex(dir, "Gemlog", true)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %+v got %+v", expected, actual)
+	}
+
+	if actual := dirIndex(dir, "Gemlog", false); actual.Feed != "" {
+		t.Errorf("Expected no feed got %q", actual.Feed)
+	}
+}
+
+func TestTagIndexes(t *testing.T) {
+	hello := &Page{Path: "gemlog/hello.gmi", Title: "Hello"}
+	about := &Page{Path: "about.gmi", Title: "About"}
+	tags := map[string][]*Page{
+		"go":         {hello},
+		"gemini kit": {hello, about},
+	}
+
+	all, pages := tagIndexes(tags)
+
+	expectedAll := Index{
+		Title: "Tags",
+		Pages: []Link{
+			{Href: "gemini-kit.gmi", Title: "gemini kit"},
+			{Href: "go.gmi", Title: "go"},
+		},
+	}
+	if !reflect.DeepEqual(all, expectedAll) {
+		t.Errorf("Expected %+v got %+v", expectedAll, all)
+	}
+
+	expected := map[string]Index{
+		"gemini-kit.gmi": {
+			Title: "Pages tagged \"gemini kit\"",
+			Pages: []Link{
+				{Href: "../about.gmi", Title: "About"},
+				{Href: "../gemlog/hello.gmi", Title: "Hello"},
+			},
+		},
+		"go.gmi": {
+			Title: "Pages tagged \"go\"",
+			Pages: []Link{{Href: "../gemlog/hello.gmi", Title: "Hello"}},
+		},
+	}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("Expected %+v got %+v", expected, pages)
+	}
+}
+
+func TestIndexLinksWithSpaces(t *testing.T) {
+	dir := &Dir{
+		Dirs: []string{"old posts"},
+		Pages: []*Page{
+			{Path: "my page.gmi", Title: "My page"},
+			{Path: "2021-01-01: new.gmi", Title: "New", Date: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	tmpl, err := loadGmiTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output strings.Builder
+	if err := tmpl.ExecuteTemplate(&output, "index.gmi", dirIndex(dir, "Home", false)); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := gmikit.Parse(strings.NewReader(output.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var targets []string
+	for _, line := range doc.Lines {
+		if link, ok := line.(gmikit.LinkLine); ok {
+			targets = append(targets, link.Target.Path)
+		}
+	}
+
+	expected := []string{"./2021-01-01: new.gmi", "old posts/", "my page.gmi"}
+	if !reflect.DeepEqual(targets, expected) {
+		t.Errorf("Expected %q got %q in\n%s", expected, targets, output.String())
+	}
+}
+
+func TestTagIndexNames(t *testing.T) {
+	page := &Page{Path: "page.gmi", Title: "Page"}
+	tags := map[string][]*Page{
+		"c":     {page},
+		"c++":   {page},
+		"index": {page},
+		"!!!":   {page},
+	}
+
+	all, pages := tagIndexes(tags)
+
+	expected := []Link{
+		{Href: "section.gmi", Title: "!!!"},
+		{Href: "c.gmi", Title: "c"},
+		{Href: "c-2.gmi", Title: "c++"},
+		{Href: "index-2.gmi", Title: "index"},
+	}
+	if !reflect.DeepEqual(all.Pages, expected) {
+		t.Errorf("Expected %+v got %+v", expected, all.Pages)
+	}
+	if len(pages) != len(tags) {
+		t.Errorf("Expected %d tag pages got %d", len(tags), len(pages))
+	}
+	if _, ok := pages["index.gmi"]; ok {
+		t.Error("Expected no tag page to be called index.gmi")
+	}
+}
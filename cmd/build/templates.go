@@ -0,0 +1,108 @@
+package main
+
+// These are used for anything the --templates directory doesn't override.
+
+const defaultGmiTemplates = `{{ define "index.gmi" -}}
+# {{ .Title }}
+{{- if .Feed }}
+
+=> {{ .Feed }} Atom feed
+{{- end }}
+{{- if .Entries }}
+{{ range .Entries }}
+=> {{ .Href }} {{ .Date }} {{ .Title }}
+{{- end }}
+{{- end }}
+{{- if .Dirs }}
+{{ range .Dirs }}
+=> {{ .Href }} {{ .Title }}
+{{- end }}
+{{- end }}
+{{- if .Pages }}
+{{ range .Pages }}
+=> {{ .Href }} {{ .Title }}
+{{- end }}
+{{- end }}
+{{ end }}`
+
+const defaultHtmlTemplates = `{{ define "page.html" -}}
+<!doctype html>
+<html>
+<head>
+<meta charset="utf-8" />
+<meta name="viewport" content="width=device-width, initial-scale=1" />
+<style>
+{{ template "style.css" .Style }}
+</style>
+<title>{{ .Title }} - {{ .Site }}</title>
+</head>
+<body>
+{{- if gt .Outline.Len 1 }}
+<nav>
+{{ template "outline" .Outline.Headings }}
+</nav>
+{{- end }}
+<article>
+{{ .Body }}
+</article>
+</body>
+</html>
+{{ end }}
+
+{{- define "outline" -}}
+<ul>
+{{- range . }}
+<li><a href="#{{ .ID }}">{{ .Text }}</a>
+{{- if .Children }}
+{{ template "outline" .Children }}
+{{- end -}}
+</li>
+{{- end }}
+</ul>
+{{- end }}
+
+{{- define "style.css" -}}
+html {
+	font-family: sans-serif;
+	background-color: {{ .Light.Background }};
+	color: {{ .Light.Paragraph }};
+	line-height: 1.3;
+}
+
+body {
+	max-width: 40em;
+	margin: 0 auto;
+	padding: 2rem;
+}
+
+h1 { color: {{ .Light.Heading1 }}; }
+h2 { color: {{ .Light.Heading2 }}; }
+h3 { color: {{ .Light.Heading3 }}; }
+a { color: {{ .Light.LinkText }}; }
+blockquote { color: {{ .Light.Quote }}; }
+pre { color: {{ .Light.Preformatted }}; overflow-x: auto; }
+
+nav {
+	font-size: smaller;
+	margin-bottom: 2rem;
+}
+
+p, blockquote {
+	white-space: pre-wrap;
+	overflow-wrap: break-word;
+}
+
+@media (prefers-color-scheme: dark) {
+	html {
+		background-color: {{ .Dark.Background }};
+		color: {{ .Dark.Paragraph }};
+	}
+
+	h1 { color: {{ .Dark.Heading1 }}; }
+	h2 { color: {{ .Dark.Heading2 }}; }
+	h3 { color: {{ .Dark.Heading3 }}; }
+	a { color: {{ .Dark.LinkText }}; }
+	blockquote { color: {{ .Dark.Quote }}; }
+	pre { color: {{ .Dark.Preformatted }}; }
+}
+{{- end }}`
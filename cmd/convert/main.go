@@ -3,14 +3,22 @@ package main
 import (
 	"io"
 	"log"
+	"net/url"
 	"os"
+	"time"
 
 	"anachronauts.club/repos/gmikit"
 	flag "github.com/spf13/pflag"
 )
 
-var format *string = flag.StringP("format", "T", "html", "Output format (gmi, html)")
+var format *string = flag.StringP("format", "T", "html", "Output format (gmi, html, text, ansi, markdown, atom, gophermap, json)")
 var output *string = flag.StringP("output", "o", "-", "Output path")
+var lenient *bool = flag.BoolP("lenient", "l", false, "Keep going on malformed links")
+var width *int = flag.IntP("width", "w", 80, "Line width for text output, or 0 to not wrap")
+var strict *bool = flag.BoolP("strict", "s", false, "Parse exactly as the gemtext specification says")
+var base *string = flag.StringP("base", "b", "", "URL the document is served at, to resolve links against")
+var fetch *bool = flag.BoolP("fetch", "F", false, "Fetch feed entries to include their content")
+var from *string = flag.StringP("from", "f", "gmi", "Input format (gmi, markdown, html, json)")
 
 func main() {
 	flag.Parse()
@@ -24,18 +32,66 @@ func main() {
 		}
 	}
 
+	parser := gmikit.Parser{Lenient: *lenient}
+	if *strict {
+		parser.Mode = gmikit.ParseStrict
+	}
+
+	var baseURL *url.URL
+	if *base != "" {
+		var err error
+		baseURL, err = url.Parse(*base)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var feed *gmikit.FeedBuilder
 	var v gmikit.Visitor
 	switch *format {
 	case "gmi":
 		v = gmikit.NewGmiWriter(w)
 	case "html":
 		v = gmikit.NewHtmlWriter(w, nil)
+	case "text":
+		v = gmikit.NewTextWriter(w, *width)
+	case "ansi":
+		v = gmikit.NewAnsiWriter(w, *width)
+	case "markdown", "md":
+		v = gmikit.NewMarkdownWriter(w)
+	case "json":
+		v = gmikit.NewJsonWriter(w)
+	case "gophermap":
+		if baseURL == nil || baseURL.Scheme != "gopher" {
+			log.Fatal("gophermap output needs a gopher --base")
+		}
+		v = gmikit.NewGophermapWriter(w, baseURL)
+	case "atom":
+		if baseURL == nil {
+			log.Fatal("atom output needs --base")
+		}
+		feed = gmikit.NewFeedBuilder(baseURL)
+		v = feed
 	default:
 		log.Fatalf("unknown format '%v'", *format)
 	}
 
+	var parse func(r io.Reader, v gmikit.Visitor) error
+	switch *from {
+	case "gmi":
+		parse = parser.ParseLines
+	case "markdown", "md":
+		parse = gmikit.MarkdownToGmi
+	case "html":
+		parse = gmikit.HtmlToGmi
+	case "json":
+		parse = gmikit.JsonToGmi
+	default:
+		log.Fatalf("unknown input format '%v'", *from)
+	}
+
 	if flag.NArg() == 0 {
-		err := gmikit.ParseLines(os.Stdin, v)
+		err := parse(os.Stdin, v)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -46,10 +102,22 @@ func main() {
 				log.Fatal(err)
 			}
 
-			err = gmikit.ParseLines(r, v)
+			err = parse(r, v)
 			if err != nil {
 				log.Fatal(err)
 			}
 		}
 	}
+
+	if feed != nil {
+		if *fetch {
+			client := &gmikit.Client{Timeout: 30 * time.Second}
+			if err := feed.Feed.Fetch(client); err != nil {
+				log.Println(err)
+			}
+		}
+		if err := feed.Feed.WriteAtom(w); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
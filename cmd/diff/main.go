@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"anachronauts.club/repos/gmikit"
+	flag "github.com/spf13/pflag"
+)
+
+var gmiOutput *bool = flag.BoolP("gmi", "g", false, "Output differences as gemtext")
+var unified *int = flag.IntP("unified", "U", 3, "Lines of context in unified output")
+
+func fatal(v ...interface{}) {
+	fmt.Fprintln(os.Stderr, v...)
+	os.Exit(2)
+}
+
+func parse(name string) *gmikit.Document {
+	r, err := os.Open(name)
+	if err != nil {
+		fatal(err)
+	}
+	defer r.Close()
+
+	parser := gmikit.Parser{Lenient: true}
+	doc, err := parser.Parse(r)
+	if err != nil {
+		fatal(fmt.Sprintf("%s: %v", name, err))
+	}
+	return doc
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 2 {
+		fatal(fmt.Sprintf("usage: %s [options] old new", os.Args[0]))
+	}
+
+	oldName, newName := flag.Arg(0), flag.Arg(1)
+	diffs := gmikit.DiffDocuments(parse(oldName), parse(newName))
+
+	var err error
+	if *gmiOutput {
+		err = gmikit.WriteGmiDiff(os.Stdout, diffs)
+	} else {
+		err = gmikit.WriteUnifiedDiff(os.Stdout, diffs, oldName, newName, *unified)
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	for _, d := range diffs {
+		if d.Op != gmikit.DiffEqual {
+			os.Exit(1)
+		}
+	}
+}
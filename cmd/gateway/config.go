@@ -12,15 +12,19 @@ import (
 )
 
 type GatewayConfig struct {
-	Bind         string            `toml:"bind"`
-	Root         string            `toml:"root"`
-	Timeout      int64             `toml:"timeout"`
-	Templates    string            `toml:"templates"`
-	RequestLog   string            `toml:"request_log"`
-	ErrorLog     string            `toml:"error_log"`
-	PidFile      string            `toml:"pid_file"`
-	ImagePattern string            `toml:"image_pattern"`
-	External     map[string]string `toml:"external"`
+	Bind          string            `toml:"bind"`
+	Root          string            `toml:"root"`
+	Timeout       int64             `toml:"timeout"`
+	Templates     string            `toml:"templates"`
+	RequestLog    string            `toml:"request_log"`
+	ErrorLog      string            `toml:"error_log"`
+	PidFile       string            `toml:"pid_file"`
+	ImagePattern  string            `toml:"image_pattern"`
+	Strict        bool              `toml:"strict"`
+	KnownHosts    string            `toml:"known_hosts"`
+	AcceptExpired string            `toml:"accept_expired"`
+	Verify        string            `toml:"verify"`
+	External      map[string]string `toml:"external"`
 }
 
 type SplitLogger struct {
@@ -38,9 +42,11 @@ func LoadConfig(path string) (*GatewayConfig, error) {
 
 	dec := toml.NewDecoder(configFile)
 	config := &GatewayConfig{
-		Bind:      ":8080",
-		Timeout:   30000,
-		Templates: templateDir,
+		Bind:          ":8080",
+		Timeout:       30000,
+		Templates:     templateDir,
+		Verify:        "tofu",
+		AcceptExpired: "never",
 	}
 	if err := dec.Decode(config); err != nil {
 		return nil, err
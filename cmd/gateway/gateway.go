@@ -4,7 +4,6 @@ import (
 	"fmt"
 	ht "html/template"
 	"io"
-	"mime"
 	"net/http"
 	"net/url"
 	"path"
@@ -24,6 +23,8 @@ type Gateway struct {
 	timeout      time.Duration
 	imagePattern *regexp.Regexp
 	externals    map[string]*tt.Template
+	knownHosts   *gmikit.KnownHosts
+	verify       gmikit.Verification
 }
 
 func NewGateway(logger *SplitLogger, config *GatewayConfig) (*Gateway, error) {
@@ -58,6 +59,37 @@ func NewGateway(logger *SplitLogger, config *GatewayConfig) (*Gateway, error) {
 		return nil, err
 	}
 
+	switch config.Verify {
+	case "tofu":
+		g.verify = gmikit.VerifyTrustOnFirstUse
+	case "ca":
+		g.verify = gmikit.VerifyCertificateAuthority
+	case "either":
+		g.verify = gmikit.VerifyEither
+	default:
+		return nil, fmt.Errorf("unknown verify mode %s", config.Verify)
+	}
+
+	var expired gmikit.ExpiredPolicy
+	switch config.AcceptExpired {
+	case "", "never":
+		expired = gmikit.ExpiredReject
+	case "known":
+		expired = gmikit.ExpiredAcceptKnown
+	case "always":
+		expired = gmikit.ExpiredAccept
+	default:
+		return nil, fmt.Errorf("unknown accept_expired policy %s", config.AcceptExpired)
+	}
+
+	if config.KnownHosts != "" {
+		g.knownHosts, err = gmikit.LoadKnownHosts(config.KnownHosts)
+		if err != nil {
+			return nil, err
+		}
+		g.knownHosts.Expired = expired
+	}
+
 	return g, nil
 }
 
@@ -77,7 +109,7 @@ func (g *Gateway) convertURL(
 		return target, nil
 	}
 
-	if target.Scheme == "gemini" && target.Host == g.rootURL.Host {
+	if gmikit.IsInternalURL(target, g.rootURL) {
 		// This is an internal URL
 		out := *target
 		out.Scheme = requestBase.Scheme
@@ -100,10 +132,30 @@ func (g *Gateway) convertURL(
 	return url.Parse(out.String())
 }
 
+// linkClass is the CSS class of a link to target, which marks links that
+// stay on the capsule as local.
+func (g *Gateway) linkClass(target *url.URL) string {
+	if !gmikit.IsInternalURL(target, g.rootURL) {
+		return target.Scheme
+	}
+	if target.Scheme == "" {
+		return "local gemini"
+	}
+	return fmt.Sprintf("local %s", target.Scheme)
+}
+
 func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
-		client := gmikit.Client{Timeout: g.timeout}
+		client := gmikit.Client{
+			Timeout: g.timeout,
+			Verify:  g.verify,
+			// Redirects are passed on to the browser to follow
+			MaxRedirects: -1,
+		}
+		if g.knownHosts != nil {
+			client.TrustCertificate = g.knownHosts.TrustCertificate
+		}
 		req := gmikit.NewRequest(&url.URL{
 			Scheme:   g.rootURL.Scheme,
 			Host:     g.rootURL.Host,
@@ -204,7 +256,7 @@ func (g *Gateway) handleSuccess(
 	resp *gmikit.Response,
 	req *gmikit.Request,
 ) {
-	m, _, err := mime.ParseMediaType(resp.Meta)
+	m, _, err := resp.MediaType()
 	if err != nil {
 		g.showError(
 			w, resp, req,
@@ -220,22 +272,27 @@ func (g *Gateway) handleSuccess(
 		return
 	}
 
+	body, err := resp.DecodedBody()
+	if err != nil {
+		g.logger.Errorf("Error decoding %s, assuming UTF-8: %v", req.URL, err)
+		body, _ = gmikit.NewUTF8Reader(resp.Body, "utf-8")
+	}
+
 	// Build render context
 	rc := NewSuccessContext(
 		g.imagePattern,
 		func(url *url.URL) (*url.URL, string, error) {
 			target, err := g.convertURL(url, r.URL)
-			class := url.Scheme
-			if !url.IsAbs() || url.Host == g.rootURL.Host {
-				if url.Scheme == "" {
-					class = "local gemini"
-				} else {
-					class = fmt.Sprintf("local %s", url.Scheme)
-				}
-			}
-			return target, class, err
+			return target, g.linkClass(url), err
 		})
-	gmikit.ParseLines(resp.Body, rc)
+	parser := gmikit.Parser{LongLines: gmikit.LongLineSplit, Lenient: true}
+	if g.config.Strict {
+		parser.Mode = gmikit.ParseStrict
+	}
+	if err := parser.ParseLines(body, rc.Visitor()); err != nil {
+		// Render whatever we got before the error, it's better than nothing
+		g.logger.Errorf("Error parsing %s: %v", req.URL, err)
+	}
 	rc.Request = req
 	rc.Response = resp
 
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	tt "text/template"
+
+	"anachronauts.club/repos/gmikit"
+)
+
+func TestConvertURL(t *testing.T) {
+	root, _ := url.Parse("gemini://example.org/")
+	g := &Gateway{
+		rootURL: root,
+		externals: map[string]*tt.Template{
+			"gemini": tt.Must(tt.New("gemini").Parse("https://proxy.example/{{.Host}}{{.Path}}")),
+		},
+	}
+	requestBase, _ := url.Parse("https://gateway.example/page")
+
+	tests := []struct {
+		target   string
+		expected string
+	}{
+		{"/about.gmi", "/about.gmi"},
+		{"gemini://example.org/a.gmi", "https://gateway.example/a.gmi"},
+		// Hosts are matched case-insensitively, and the default port is the
+		// same as no port at all
+		{"gemini://EXAMPLE.org/b.gmi", "https://gateway.example/b.gmi"},
+		{"gemini://example.org:1965/c.gmi", "https://gateway.example/c.gmi"},
+		{"gemini://example.org:1966/d.gmi", "https://proxy.example/example.org:1966/d.gmi"},
+		{"gemini://other.org/", "https://proxy.example/other.org/"},
+		{"https://example.org/", "https://example.org/"},
+	}
+
+	for _, test := range tests {
+		target, _ := url.Parse(test.target)
+		actual, err := g.convertURL(target, requestBase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual.String() != test.expected {
+			t.Errorf("%s: expected %s got %s", test.target, test.expected, actual)
+		}
+	}
+}
+
+func TestLinkClass(t *testing.T) {
+	root, _ := url.Parse("gemini://example.org/")
+	g := &Gateway{rootURL: root}
+
+	tests := []struct {
+		target   string
+		expected string
+	}{
+		{"/x.png", "local gemini"},
+		{"gemini://example.org/x.png", "local gemini"},
+		{"gemini://EXAMPLE.org/x.png", "local gemini"},
+		{"gemini://example.org:1965/x.png", "local gemini"},
+		{"gemini://example.org:1966/x.png", "gemini"},
+		{"gemini://other.org/x.png", "gemini"},
+		{"https://example.org/x.png", "https"},
+	}
+
+	for _, test := range tests {
+		target, _ := url.Parse(test.target)
+		if actual := g.linkClass(target); actual != test.expected {
+			t.Errorf("%s: expected %q got %q", test.target, test.expected, actual)
+		}
+	}
+}
+
+func TestMalformedImageLink(t *testing.T) {
+	ctx := NewSuccessContext(
+		regexp.MustCompile(`\.png$`),
+		func(target *url.URL) (*url.URL, string, error) {
+			return target, "local gemini", nil
+		})
+	parser := gmikit.Parser{Lenient: true}
+	input := "=> /my%image.png A picture\n"
+	if err := parser.ParseLines(strings.NewReader(input), ctx.Visitor()); err != nil {
+		t.Fatal(err)
+	}
+
+	body := string(ctx.Body())
+	if !strings.Contains(body, `<img alt="A picture" src="/my%25image.png" />`) {
+		t.Errorf("Expected an inline image got %s", body)
+	}
+}
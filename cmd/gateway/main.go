@@ -24,6 +24,10 @@ var configFile *string = flag.StringP(
 	path.Join(confDir, "gateway.conf"),
 	"Path to config",
 )
+var knownHosts *string = flag.String(
+	"known-hosts", "",
+	"Path to known hosts file, overriding the config",
+)
 var templateDir = path.Join(dataDir, "templates")
 
 func isProcessRunning(pid int) bool {
@@ -70,6 +74,9 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
 		os.Exit(1)
 	}
+	if *knownHosts != "" {
+		config.KnownHosts = *knownHosts
+	}
 
 	// Create logger
 	logger, err := config.MakeLoggers()
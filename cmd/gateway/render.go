@@ -29,7 +29,8 @@ type SuccessContext struct {
 	RenderContext
 	gmikit.HtmlWriter
 	Title        string
-	titleLevel   int
+	Outline      *gmikit.Outline
+	outline      *gmikit.OutlineBuilder
 	bodyBuilder  *strings.Builder
 	imagePattern *regexp.Regexp
 }
@@ -96,11 +97,13 @@ func NewSuccessContext(
 	rewriter gmikit.UrlRewriter,
 ) *SuccessContext {
 	ctx := &SuccessContext{
-		titleLevel:   4, // gemini only supports 3 levels
 		bodyBuilder:  &strings.Builder{},
 		imagePattern: imagePattern,
+		outline:      gmikit.NewOutlineBuilder(),
 	}
+	ctx.Outline = ctx.outline.Outline
 	ctx.HtmlWriter = *gmikit.NewHtmlWriter(ctx.bodyBuilder, rewriter)
+	ctx.HtmlWriter.HeadingIDs = true
 	return ctx
 }
 
@@ -108,30 +111,34 @@ func (ctx *SuccessContext) Body() template.HTML {
 	return template.HTML(ctx.bodyBuilder.String())
 }
 
-func (ctx *SuccessContext) Heading1(text string) error {
-	if ctx.titleLevel > 1 {
-		ctx.Title = text
-		ctx.titleLevel = 1
+// Visitor returns the visitor which builds the context from a page.
+func (ctx *SuccessContext) Visitor() gmikit.Visitor {
+	title := &titleFinder{
+		title: &ctx.Title,
+		level: 4, // gemini only supports 3 levels
 	}
-	return ctx.HtmlWriter.Heading1(text)
+	return gmikit.Tee(title, ctx.outline, ctx)
 }
 
-func (ctx *SuccessContext) Heading2(text string) error {
-	if ctx.titleLevel > 2 {
-		ctx.Title = text
-		ctx.titleLevel = 2
-	}
-	return ctx.HtmlWriter.Heading2(text)
+// titleFinder picks the first of the highest level headings on a page.
+type titleFinder struct {
+	gmikit.BaseVisitor
+	title *string
+	level int
 }
 
-func (ctx *SuccessContext) Heading3(text string) error {
-	if ctx.titleLevel > 3 {
-		ctx.Title = text
-		ctx.titleLevel = 3
+func (t *titleFinder) heading(level int, text string) error {
+	if t.level > level {
+		*t.title = text
+		t.level = level
 	}
-	return ctx.HtmlWriter.Heading3(text)
+	return nil
 }
 
+func (t *titleFinder) Heading1(text string) error { return t.heading(1, text) }
+func (t *titleFinder) Heading2(text string) error { return t.heading(2, text) }
+func (t *titleFinder) Heading3(text string) error { return t.heading(3, text) }
+
 var image = template.Must(
 	template.New("image").Parse(
 		"<a href=\"{{.Href}}\" " +
@@ -169,6 +176,15 @@ func (ctx *SuccessContext) Link(target *url.URL, friendlyName string) error {
 	return ctx.HtmlWriter.Link(target, friendlyName)
 }
 
+// MalformedLink repairs the target if it can, so that repaired images are
+// still shown inline.
+func (ctx *SuccessContext) MalformedLink(target string, friendlyName string, err error) error {
+	if url, rerr := gmikit.RepairURL(target); rerr == nil {
+		return ctx.Link(url, friendlyName)
+	}
+	return ctx.HtmlWriter.MalformedLink(target, friendlyName, err)
+}
+
 func (ctx *ErrorContext) HTTPFriendly() string {
 	return http.StatusText(ctx.HTTPStatus)
 }
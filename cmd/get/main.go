@@ -1,20 +1,30 @@
 package main
 
 import (
-	"crypto/sha256"
+	"bufio"
 	"crypto/x509"
-	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/url"
 	"os"
+	"strings"
 
 	"anachronauts.club/repos/gmikit"
 	flag "github.com/spf13/pflag"
 )
 
 var output *string = flag.StringP("output", "o", "-", "Output path")
-var redirect *int = flag.IntP("redirect", "r", 5, "Maximum number of redirects")
+var redirect *int = flag.IntP("redirect", "r", gmikit.DefaultMaxRedirects, "Maximum number of redirects")
+var confirmHosts *bool = flag.Bool("confirm-hosts", false, "Ask before following redirects to other hosts")
+var knownHosts *string = flag.StringP("known-hosts", "k", "", "Path to known hosts file")
+var acceptExpired *string = flag.String("accept-expired", "never",
+	"Whether to accept expired certificates: never, known or always")
+var identities *string = flag.StringP("identities", "i", "",
+	"Path to identity directory, to use assigned client certificates")
+var verify *string = flag.String("verify", "tofu",
+	"How to verify servers: tofu, ca or either")
 
 func main() {
 	flag.Parse()
@@ -38,44 +48,88 @@ func main() {
 
 	client := &gmikit.Client{
 		TrustCertificate: func(hostname string, cert *x509.Certificate) error {
-			fingerprint := sha256.Sum256(cert.Raw)
-			log.Println("Fingerprint", hostname, hex.EncodeToString(fingerprint[:]))
+			log.Println("Fingerprint", hostname, gmikit.Fingerprint(cert))
 			return nil
 		},
 	}
-
-	req := gmikit.NewRequest(url)
-	for {
-		resp, err := client.Do(req)
+	switch *verify {
+	case "tofu":
+		client.Verify = gmikit.VerifyTrustOnFirstUse
+	case "ca":
+		client.Verify = gmikit.VerifyCertificateAuthority
+	case "either":
+		client.Verify = gmikit.VerifyEither
+	default:
+		log.Fatalf("unknown --verify mode %s", *verify)
+	}
+	if *identities != "" {
+		store, err := gmikit.LoadIdentities(*identities)
+		if err != nil {
+			log.Fatal(err)
+		}
+		client.Certificate = store.Certificate
+	}
+	if *knownHosts != "" {
+		hosts, err := gmikit.LoadKnownHosts(*knownHosts)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer resp.Close()
+		switch *acceptExpired {
+		case "never":
+			hosts.Expired = gmikit.ExpiredReject
+		case "known":
+			hosts.Expired = gmikit.ExpiredAcceptKnown
+		case "always":
+			hosts.Expired = gmikit.ExpiredAccept
+		default:
+			log.Fatalf("unknown --accept-expired policy %s", *acceptExpired)
+		}
+		client.TrustCertificate = hosts.TrustCertificate
+	}
 
-		switch resp.Status.Class() {
-		case gmikit.StatusClassSuccess:
-			_, err := io.Copy(w, resp.Body)
-			if err != nil {
-				log.Fatal(err)
-			}
-			os.Exit(0)
+	client.MaxRedirects = *redirect
+	if *redirect == 0 {
+		client.MaxRedirects = -1
+	}
+	client.CheckRedirect = func(req *gmikit.Request, via []*gmikit.Request) error {
+		log.Println("Redirect to", req.URL)
+		return nil
+	}
+	if *confirmHosts {
+		client.ConfirmRedirect = func(req *gmikit.Request, via []*gmikit.Request) bool {
+			fmt.Fprintf(os.Stderr, "Follow redirect to %s? [y/N] ", req.URL)
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			return answer == "y" || answer == "yes"
+		}
+	}
 
-		case gmikit.StatusClassRedirect:
-			log.Println(resp.Status, resp.Meta)
-			url, err := url.Parse(resp.Meta)
-			if err != nil {
-				log.Fatal(err)
-			}
-			req.URL = url
-			if *redirect > 0 {
-				*redirect--
-			} else {
-				os.Exit(int(resp.Status))
-			}
+	resp, err := client.Do(gmikit.NewRequest(url))
+	var mismatch *gmikit.ErrCertificateMismatch
+	if errors.As(err, &mismatch) {
+		log.Printf("Certificate for %s has changed!", mismatch.Host)
+		log.Println("Known fingerprint:", mismatch.Old)
+		log.Println("New fingerprint:  ", mismatch.New)
+		log.Fatalf("Remove %s from %s to trust the new certificate", mismatch.Host, *knownHosts)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Close()
 
-		default:
-			log.Print(resp.Status)
-			os.Exit(int(resp.Status))
+	switch resp.Status.Class() {
+	case gmikit.StatusClassSuccess:
+		_, err := io.Copy(w, resp.Body)
+		if err != nil {
+			log.Fatal(err)
 		}
+		os.Exit(0)
+
+	case gmikit.StatusClassRedirect:
+		log.Println(resp.Status, resp.Meta)
+		os.Exit(int(resp.Status))
+
+	default:
+		log.Print(resp.Status)
+		os.Exit(int(resp.Status))
 	}
 }
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"anachronauts.club/repos/gmikit"
+	flag "github.com/spf13/pflag"
+)
+
+var dir *string = flag.StringP("dir", "d", defaultDir(), "Path to identity directory")
+
+func defaultDir() string {
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return "identities"
+	}
+	return filepath.Join(config, "gmikit", "identities")
+}
+
+func fatal(v ...interface{}) {
+	fmt.Fprintln(os.Stderr, v...)
+	os.Exit(1)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: %s [options] command [arguments]
+
+commands:
+  create [--type ed25519|ecdsa|rsa] [--lifetime days] [--common-name cn] name
+  list
+  export [--output path] name
+  assign name prefix...
+  unassign prefix...
+
+options:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.CommandLine.SetInterspersed(false)
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	store, err := gmikit.LoadIdentities(*dir)
+	if err != nil {
+		fatal(err)
+	}
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	switch command {
+	case "create":
+		create(store, args)
+	case "list":
+		list(store)
+	case "export":
+		export(store, args)
+	case "assign":
+		if len(args) < 2 {
+			fatal("usage: assign name prefix...")
+		}
+		for _, prefix := range args[1:] {
+			if err := store.Assign(prefix, args[0]); err != nil {
+				fatal(err)
+			}
+		}
+	case "unassign":
+		for _, prefix := range args {
+			if err := store.Unassign(prefix); err != nil {
+				fatal(err)
+			}
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func create(store *gmikit.IdentityStore, args []string) {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	keyType := flags.StringP("type", "t", "ed25519", "Key type: ed25519, ecdsa or rsa")
+	lifetime := flags.IntP("lifetime", "l", 365, "Days until the certificate expires")
+	commonName := flags.StringP("common-name", "c", "", "Common name, if not the identity name")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fatal("usage: create [options] name")
+	}
+	name := flags.Arg(0)
+	if *commonName == "" {
+		*commonName = name
+	}
+
+	var kind gmikit.KeyType
+	switch strings.ToLower(*keyType) {
+	case "ed25519":
+		kind = gmikit.KeyEd25519
+	case "ecdsa":
+		kind = gmikit.KeyEcdsa
+	case "rsa":
+		kind = gmikit.KeyRsa
+	default:
+		fatal("unknown key type", *keyType)
+	}
+
+	cert, err := gmikit.GenerateCertificate(*commonName, kind, time.Duration(*lifetime)*24*time.Hour)
+	if err != nil {
+		fatal(err)
+	}
+	if _, err := store.Add(name, cert); err != nil {
+		fatal(err)
+	}
+	fmt.Println(name, gmikit.Fingerprint(cert.Leaf))
+}
+
+func list(store *gmikit.IdentityStore) {
+	for _, id := range store.Identities() {
+		leaf := id.Certificate.Leaf
+		kind := "unknown"
+		switch id.Certificate.PrivateKey.(type) {
+		case ed25519.PrivateKey:
+			kind = "ed25519"
+		case *ecdsa.PrivateKey:
+			kind = "ecdsa"
+		case *rsa.PrivateKey:
+			kind = "rsa"
+		}
+
+		fmt.Printf("%s\n", id.Name)
+		fmt.Printf("  Common name: %s\n", leaf.Subject.CommonName)
+		fmt.Printf("  Key type:    %s\n", kind)
+		fmt.Printf("  Expires:     %s\n", leaf.NotAfter.Format(time.RFC3339))
+		fmt.Printf("  Fingerprint: %s\n", gmikit.Fingerprint(leaf))
+		for _, prefix := range id.Prefixes {
+			fmt.Printf("  Assigned to: %s\n", prefix)
+		}
+	}
+}
+
+func export(store *gmikit.IdentityStore, args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.StringP("output", "o", "-", "Output path")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fatal("usage: export [options] name")
+	}
+
+	id, ok := store.Get(flags.Arg(0))
+	if !ok {
+		fatal(fmt.Sprintf("%v \"%s\"", gmikit.ErrUnknownIdentity, flags.Arg(0)))
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := gmikit.WriteCertificatePem(w, id.Certificate); err != nil {
+		fatal(err)
+	}
+}
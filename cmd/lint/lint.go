@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"anachronauts.club/repos/gmikit"
+)
+
+type Problem struct {
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// String formats the problem like a compiler error. Problems with the whole
+// document, rather than a line, have no line number.
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %s (%s)", p.File, p.Message, p.Rule)
+	}
+	return fmt.Sprintf("%s:%d: %s (%s)", p.File, p.Line, p.Message, p.Rule)
+}
+
+type linter struct {
+	gmikit.BaseVisitor
+	file     string
+	dir      string
+	root     string
+	pos      gmikit.Position
+	pre      bool
+	preStart int
+	level    int
+	title    bool
+	problems []Problem
+}
+
+func (l *linter) report(line int, rule string, format string, v ...interface{}) {
+	l.problems = append(l.problems, Problem{
+		File:    l.file,
+		Line:    line,
+		Rule:    rule,
+		Message: fmt.Sprintf(format, v...),
+	})
+}
+
+func (l *linter) Position(pos gmikit.Position) error {
+	l.pos = pos
+	// Toggle lines are gemtext even when they close a block
+	text := !l.pre || strings.HasPrefix(pos.Raw, "```")
+	if text && strings.TrimRight(pos.Raw, " \t") != pos.Raw {
+		l.report(pos.Line, "trailing-whitespace", "trailing whitespace")
+	}
+	return nil
+}
+
+func (l *linter) End() error {
+	if l.pre {
+		l.report(l.preStart, "unclosed-pre", "preformatted block is never closed")
+	}
+	if !l.title {
+		l.report(0, "no-title", "document has no top-level heading")
+	}
+	return nil
+}
+
+func (l *linter) Text(text string) error {
+	if strings.HasPrefix(text, "=>") {
+		// Strict parsing turns links without a target into text
+		l.report(l.pos.Line, "empty-link", "link has no target")
+	} else if strings.HasPrefix(text, "*") && len(text) > 1 &&
+		!strings.Contains(text[1:], "*") {
+		l.report(l.pos.Line, "list-space", "list item needs a space after \"*\"")
+	}
+	return nil
+}
+
+func (l *linter) Link(target *url.URL, friendlyName string) error {
+	if target.String() == "" {
+		l.report(l.pos.Line, "empty-link", "link has no target")
+		return nil
+	}
+	if target.IsAbs() || target.Host != "" || target.Path == "" {
+		return nil
+	}
+
+	var path string
+	if strings.HasPrefix(target.Path, "/") {
+		if l.root == "" {
+			return nil
+		}
+		path = filepath.Join(l.root, filepath.FromSlash(target.Path))
+	} else {
+		path = filepath.Join(l.dir, filepath.FromSlash(target.Path))
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		l.report(l.pos.Line, "missing-target",
+			"link target \"%s\" does not exist", target.Path)
+	}
+	return nil
+}
+
+func (l *linter) MalformedLink(target string, friendlyName string, err error) error {
+	l.report(l.pos.Line, "bad-link", "%v", err)
+	return nil
+}
+
+func (l *linter) PreformattingToggle(altText string) error {
+	l.pre = !l.pre
+	if l.pre {
+		l.preStart = l.pos.Line
+	}
+	return nil
+}
+
+func (l *linter) heading(level int) error {
+	if level == 1 {
+		l.title = true
+	}
+	if l.level != 0 && level > l.level+1 {
+		l.report(l.pos.Line, "heading-jump",
+			"level %d heading follows level %d", level, l.level)
+	}
+	l.level = level
+	return nil
+}
+
+func (l *linter) Heading1(text string) error { return l.heading(1) }
+func (l *linter) Heading2(text string) error { return l.heading(2) }
+func (l *linter) Heading3(text string) error { return l.heading(3) }
+
+// lint checks a gemtext document for problems. Relative link targets are
+// looked for next to the file called name, and absolute paths under root,
+// unless it's empty.
+func lint(name string, r io.Reader, root string) ([]Problem, error) {
+	l := &linter{file: name, dir: filepath.Dir(name), root: root}
+	parser := gmikit.Parser{
+		Lenient: true,
+		Mode:    gmikit.ParseStrict,
+	}
+	if err := parser.ParseLines(r, l); err != nil {
+		return nil, err
+	}
+	return l.problems, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "exists.gmi"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "root"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "page.gmi")
+
+	type problem struct {
+		Line int
+		Rule string
+	}
+	tests := []struct {
+		rule     string
+		input    string
+		root     string
+		expected []problem
+	}{
+		{"trailing-whitespace", "# Title \nText\t\n```\npre  \n```\n", "",
+			[]problem{{1, "trailing-whitespace"}, {2, "trailing-whitespace"}}},
+		{"trailing-whitespace", "# Title\n```\npre\n``` \n", "",
+			[]problem{{4, "trailing-whitespace"}}},
+		{"empty-link", "# Title\n=>\nText\n=>\t\n", "",
+			[]problem{{2, "empty-link"}, {4, "trailing-whitespace"}, {4, "empty-link"}}},
+		{"list-space", "# Title\n*item\n* item\n*emphasis*\n", "",
+			[]problem{{2, "list-space"}}},
+		{"missing-target", "# Title\n=> exists.gmi\n=> missing.gmi\n" +
+			"=> gemini://example.org/missing.gmi\n=> #fragment\n", "",
+			[]problem{{3, "missing-target"}}},
+		{"missing-target", "# Title\n=> /missing.gmi\n", "",
+			nil},
+		{"missing-target", "# Title\n=> /missing.gmi\n", filepath.Join(dir, "root"),
+			[]problem{{2, "missing-target"}}},
+		{"bad-link", "# Title\n=> %zz Broken\n", "",
+			[]problem{{2, "bad-link"}}},
+		{"heading-jump", "# Title\n### Three\n## Two\n### Three\n# One\n### Three\n", "",
+			[]problem{{2, "heading-jump"}, {6, "heading-jump"}}},
+		{"no-title", "## Sub\nText\n", "",
+			[]problem{{0, "no-title"}}},
+		{"no-title", "", "",
+			[]problem{{0, "no-title"}}},
+		{"unclosed-pre", "# Title\n```\nfine\n```\n```alt\nnever closed\n", "",
+			[]problem{{5, "unclosed-pre"}}},
+	}
+
+	for _, test := range tests {
+		problems, err := lint(name, strings.NewReader(test.input), test.root)
+		if err != nil {
+			t.Fatalf("%s: %v", test.rule, err)
+		}
+
+		var actual []problem
+		for _, p := range problems {
+			if p.File != name {
+				t.Errorf("%s: expected file %s got %s", test.rule, name, p.File)
+			}
+			actual = append(actual, problem{p.Line, p.Rule})
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: %q: expected %v got %v", test.rule, test.input, test.expected, actual)
+		}
+	}
+}
+
+func TestProblemString(t *testing.T) {
+	tests := []struct {
+		problem  Problem
+		expected string
+	}{
+		{Problem{File: "a.gmi", Line: 3, Rule: "bad-link", Message: "oops"},
+			"a.gmi:3: oops (bad-link)"},
+		{Problem{File: "a.gmi", Rule: "no-title", Message: "no heading"},
+			"a.gmi: no heading (no-title)"},
+	}
+
+	for _, test := range tests {
+		if actual := test.problem.String(); actual != test.expected {
+			t.Errorf("Expected %q got %q", test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	flag "github.com/spf13/pflag"
+)
+
+var jsonOutput *bool = flag.BoolP("json", "j", false, "Output problems as JSON")
+var root *string = flag.StringP("root", "r", "", "Directory absolute link paths are relative to")
+
+func fatal(v ...interface{}) {
+	fmt.Fprintln(os.Stderr, v...)
+	os.Exit(2)
+}
+
+func main() {
+	flag.Parse()
+
+	var problems []Problem
+	if flag.NArg() == 0 {
+		p, err := lint("-", os.Stdin, *root)
+		if err != nil {
+			fatal(err)
+		}
+		problems = append(problems, p...)
+	} else {
+		for _, arg := range flag.Args() {
+			r, err := os.Open(arg)
+			if err != nil {
+				fatal(err)
+			}
+
+			p, err := lint(arg, r, *root)
+			r.Close()
+			if err != nil {
+				fatal(fmt.Sprintf("%s: %v", arg, err))
+			}
+			problems = append(problems, p...)
+		}
+	}
+
+	if *jsonOutput {
+		if problems == nil {
+			problems = []Problem{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(problems); err != nil {
+			fatal(err)
+		}
+	} else {
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+	}
+
+	if len(problems) != 0 {
+		os.Exit(1)
+	}
+}
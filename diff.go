@@ -0,0 +1,505 @@
+package gmikit
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+type DiffOp int
+
+const (
+	DiffEqual DiffOp = iota
+	DiffInsert
+	DiffDelete
+	DiffChange
+)
+
+// A Difference is one line of a comparison between two documents. Old is nil
+// for inserted lines and New is nil for deleted ones. Changed lines are the
+// same kind of line, like two headings at the same level, or two links with
+// the same label or target.
+type Difference struct {
+	Op  DiffOp
+	Old Line
+	New Line
+}
+
+// Kind is the kind of line that differs: "text", "link", "heading", "list
+// item", "quote" or "preformatted block".
+func (d Difference) Kind() string {
+	if d.New != nil {
+		return lineKind(d.New)
+	}
+	return lineKind(d.Old)
+}
+
+// Description says what happened to the line, like "inserted heading" or
+// "retargeted link".
+func (d Difference) Description() string {
+	kind := d.Kind()
+	switch d.Op {
+	case DiffInsert:
+		return "inserted " + kind
+	case DiffDelete:
+		return "removed " + kind
+	case DiffChange:
+		if kind == "link" {
+			oldTarget, oldLabel := linkParts(d.Old)
+			newTarget, newLabel := linkParts(d.New)
+			if oldLabel == newLabel && oldTarget != newTarget {
+				return "retargeted link"
+			} else if oldTarget == newTarget && oldLabel != newLabel {
+				return "relabelled link"
+			}
+		}
+		return "changed " + kind
+	default:
+		return "unchanged " + kind
+	}
+}
+
+func lineKind(l Line) string {
+	switch l.(type) {
+	case LinkLine, MalformedLinkLine:
+		return "link"
+	case HeadingLine:
+		return "heading"
+	case ListItemLine:
+		return "list item"
+	case QuoteLine:
+		return "quote"
+	case PreformattedBlock:
+		return "preformatted block"
+	default:
+		return "text"
+	}
+}
+
+func linkParts(l Line) (string, string) {
+	switch l := l.(type) {
+	case LinkLine:
+		return l.Target.String(), l.FriendlyName
+	case MalformedLinkLine:
+		return l.Target, l.FriendlyName
+	}
+	return "", ""
+}
+
+// lineKey is what two lines have to share to be the same, ignoring where
+// they came from.
+func lineKey(l Line) string {
+	switch l := l.(type) {
+	case TextLine:
+		return "text\x00" + l.Text
+	case LinkLine, MalformedLinkLine:
+		target, label := linkParts(l)
+		return "link\x00" + target + "\x00" + label
+	case HeadingLine:
+		return fmt.Sprintf("heading\x00%d\x00%s", l.Level, l.Text)
+	case ListItemLine:
+		return "list\x00" + l.Text
+	case QuoteLine:
+		return "quote\x00" + l.Text
+	case PreformattedBlock:
+		return "pre\x00" + l.AltText + "\x00" + strings.Join(l.Lines, "\n")
+	default:
+		return fmt.Sprintf("%T\x00%v", l, l)
+	}
+}
+
+// similar decides whether a removed and an inserted line are better shown as
+// a change.
+func similar(a, b Line) bool {
+	if lineKind(a) != lineKind(b) {
+		return false
+	}
+	switch a := a.(type) {
+	case LinkLine, MalformedLinkLine:
+		oldTarget, oldLabel := linkParts(a)
+		newTarget, newLabel := linkParts(b)
+		return oldTarget == newTarget || oldLabel == newLabel
+	case HeadingLine:
+		return a.Level == b.(HeadingLine).Level
+	}
+	return true
+}
+
+// DiffDocuments compares two documents line by line. Preformatted blocks are
+// compared as a whole.
+func DiffDocuments(old, new *Document) []Difference {
+	a, b := old.Lines, new.Lines
+
+	// Lines are compared by number, with equal lines given the same number
+	ids := make(map[string]int)
+	id := func(l Line) int {
+		key := lineKey(l)
+		n, ok := ids[key]
+		if !ok {
+			n = len(ids)
+			ids[key] = n
+		}
+		return n
+	}
+	idsA := make([]int, len(a))
+	for i, l := range a {
+		idsA[i] = id(l)
+	}
+	idsB := make([]int, len(b))
+	for i, l := range b {
+		idsB[i] = id(l)
+	}
+
+	var diffs []Difference
+	i, j := 0, 0
+	for _, m := range commonLines(idsA, idsB) {
+		diffs = append(diffs, pairChanges(a[i:m[0]], b[j:m[1]])...)
+		diffs = append(diffs, Difference{Op: DiffEqual, Old: a[m[0]], New: b[m[1]]})
+		i, j = m[0]+1, m[1]+1
+	}
+	return append(diffs, pairChanges(a[i:], b[j:])...)
+}
+
+// commonLines finds the positions in a and b of a longest common subsequence
+// of them. Lines the documents start and end with are matched up first, as
+// they usually make up most of them.
+func commonLines(a, b []int) [][2]int {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var matches [][2]int
+	for k := 0; k < prefix; k++ {
+		matches = append(matches, [2]int{k, k})
+	}
+	matches = hirschberg(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix],
+		prefix, prefix, matches)
+	for k := suffix; k > 0; k-- {
+		matches = append(matches, [2]int{len(a) - k, len(b) - k})
+	}
+	return matches
+}
+
+// hirschberg appends the matches of a longest common subsequence of a and b
+// to matches, offset by i and j. It needs space in proportion to the length
+// of b, rather than a table of every pair of lines.
+func hirschberg(a, b []int, i, j int, matches [][2]int) [][2]int {
+	if len(a) == 0 || len(b) == 0 {
+		return matches
+	}
+	if len(a) == 1 {
+		for k, line := range b {
+			if line == a[0] {
+				return append(matches, [2]int{i, j + k})
+			}
+		}
+		return matches
+	}
+
+	// Split b where the best subsequence passes through the middle of a
+	mid := len(a) / 2
+	before := lcsLengths(a[:mid], b, false)
+	after := lcsLengths(a[mid:], b, true)
+	split, best := 0, -1
+	for k := 0; k <= len(b); k++ {
+		if n := before[k] + after[k]; n > best {
+			split, best = k, n
+		}
+	}
+
+	matches = hirschberg(a[:mid], b[:split], i, j, matches)
+	return hirschberg(a[mid:], b[split:], i+mid, j+split, matches)
+}
+
+// lcsLengths gives the length of the longest common subsequence of a and
+// each prefix b[:k] of b, or with reverse set, of a and each suffix b[k:].
+func lcsLengths(a, b []int, reverse bool) []int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for x := range a {
+		if reverse {
+			x = len(a) - 1 - x
+			for k := len(b) - 1; k >= 0; k-- {
+				if a[x] == b[k] {
+					cur[k] = prev[k+1] + 1
+				} else if prev[k] >= cur[k+1] {
+					cur[k] = prev[k]
+				} else {
+					cur[k] = cur[k+1]
+				}
+			}
+		} else {
+			for k := 1; k <= len(b); k++ {
+				if a[x] == b[k-1] {
+					cur[k] = prev[k-1] + 1
+				} else if prev[k] >= cur[k-1] {
+					cur[k] = prev[k]
+				} else {
+					cur[k] = cur[k-1]
+				}
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev
+}
+
+// pairChanges turns a run of removed lines and the inserted lines which
+// replaced them into differences, pairing up similar lines as changes.
+func pairChanges(removed, inserted []Line) []Difference {
+	var diffs []Difference
+	paired := make([]bool, len(inserted))
+	next := 0
+	for _, old := range removed {
+		match := -1
+		for j := next; j < len(inserted); j++ {
+			if !paired[j] && similar(old, inserted[j]) {
+				match = j
+				break
+			}
+		}
+		if match == -1 {
+			diffs = append(diffs, Difference{Op: DiffDelete, Old: old})
+			continue
+		}
+
+		// Inserted lines before the match stay where they were
+		for ; next < match; next++ {
+			if !paired[next] {
+				paired[next] = true
+				diffs = append(diffs, Difference{Op: DiffInsert, New: inserted[next]})
+			}
+		}
+		paired[match] = true
+		diffs = append(diffs, Difference{Op: DiffChange, Old: old, New: inserted[match]})
+		next = match + 1
+	}
+	for j, l := range inserted {
+		if !paired[j] {
+			diffs = append(diffs, Difference{Op: DiffInsert, New: l})
+		}
+	}
+	return diffs
+}
+
+// renderLine writes a line as gemtext, as it appeared in the source if it was
+// parsed.
+func renderLine(l Line) ([]string, error) {
+	var b strings.Builder
+	g := NewGmiWriter(&b)
+	g.Lossless = true
+	if err := l.Visit(g); err != nil {
+		return nil, err
+	}
+	text := strings.TrimSuffix(b.String(), "\n")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines, nil
+}
+
+type diffText struct {
+	op   byte
+	text string
+}
+
+// WriteUnifiedDiff writes differences in the unified format used by diff -u,
+// with context lines of unchanged text around each hunk.
+func WriteUnifiedDiff(w io.Writer, diffs []Difference, oldName, newName string, context int) error {
+	var lines []diffText
+	for _, d := range diffs {
+		var oldLines, newLines []string
+		var err error
+		if d.Old != nil {
+			if oldLines, err = renderLine(d.Old); err != nil {
+				return err
+			}
+		}
+		if d.New != nil && d.Op != DiffEqual {
+			if newLines, err = renderLine(d.New); err != nil {
+				return err
+			}
+		}
+
+		op := byte('-')
+		if d.Op == DiffEqual {
+			op = ' '
+		}
+		for _, text := range oldLines {
+			lines = append(lines, diffText{op, text})
+		}
+		for _, text := range newLines {
+			lines = append(lines, diffText{'+', text})
+		}
+	}
+
+	header := false
+	oldLine, newLine := 1, 1
+	for start := 0; start < len(lines); {
+		// Find the next change, and the end of the hunk around it
+		first := start
+		for first < len(lines) && lines[first].op == ' ' {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+		hunkStart := first - context
+		if hunkStart < start {
+			hunkStart = start
+		}
+		end := first
+		for end < len(lines) {
+			if lines[end].op != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(lines) && lines[run].op == ' ' {
+				run++
+			}
+			if run == len(lines) || run-end > 2*context {
+				end += context
+				if end > run {
+					end = run
+				}
+				break
+			}
+			end = run
+		}
+
+		oldLine += hunkStart - start
+		newLine += hunkStart - start
+		oldCount, newCount := 0, 0
+		for _, l := range lines[hunkStart:end] {
+			if l.op != '+' {
+				oldCount++
+			}
+			if l.op != '-' {
+				newCount++
+			}
+		}
+
+		if !header {
+			if _, err := fmt.Fprintf(w, "--- %s\n+++ %s\n", oldName, newName); err != nil {
+				return err
+			}
+			header = true
+		}
+		_, err := fmt.Fprintf(w, "@@ -%s +%s @@\n",
+			hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+		if err != nil {
+			return err
+		}
+		for _, l := range lines[hunkStart:end] {
+			if _, err := fmt.Fprintf(w, "%c%s\n", l.op, l.text); err != nil {
+				return err
+			}
+		}
+
+		oldLine += oldCount
+		newLine += newCount
+		start = end
+	}
+	return nil
+}
+
+func hunkRange(line, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", line-1)
+	} else if count == 1 {
+		return fmt.Sprintf("%d", line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
+
+// WriteGmiDiff writes differences as a gemtext report, with a heading for
+// each difference followed by the lines involved. Links are written as links,
+// so the report can be followed in a browser.
+func WriteGmiDiff(w io.Writer, diffs []Difference) error {
+	g := NewGmiWriter(w)
+	newLine := 1
+	count := 0
+	for _, d := range diffs {
+		line := newLine
+		if d.New != nil {
+			lines, err := renderLine(d.New)
+			if err != nil {
+				return err
+			}
+			newLine += len(lines)
+		}
+		if d.Op == DiffEqual {
+			continue
+		}
+
+		if count > 0 {
+			if err := g.Text(""); err != nil {
+				return err
+			}
+		}
+		count++
+		title := fmt.Sprintf("Line %d: %s", line, d.Description())
+		if err := g.Heading2(title); err != nil {
+			return err
+		}
+
+		switch d.Op {
+		case DiffInsert:
+			if err := writeInserted(g, d.New); err != nil {
+				return err
+			}
+		case DiffDelete:
+			if err := writeRemoved(g, d.Old); err != nil {
+				return err
+			}
+		case DiffChange:
+			if err := writeRemoved(g, d.Old); err != nil {
+				return err
+			}
+			if err := writeInserted(g, d.New); err != nil {
+				return err
+			}
+		}
+	}
+
+	if count == 0 {
+		return g.Text("No differences.")
+	}
+	return nil
+}
+
+// writeInserted writes a line as it is in the new document. Preformatted
+// blocks are always closed, even if the document never closed them, so the
+// rest of the report isn't taken as preformatted text.
+func writeInserted(g *GmiWriter, l Line) error {
+	if err := l.Visit(g); err != nil {
+		return err
+	}
+	if g.pre {
+		return g.PreformattingToggle("")
+	}
+	return nil
+}
+
+// writeRemoved writes a line which is no longer in the document as quoted
+// text, so that it stands out from what replaced it.
+func writeRemoved(g *GmiWriter, l Line) error {
+	lines, err := renderLine(l)
+	if err != nil {
+		return err
+	}
+	for _, text := range lines {
+		if err := g.Quote(text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
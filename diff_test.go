@@ -0,0 +1,174 @@
+package gmikit
+
+import (
+	"strings"
+	"testing"
+)
+
+func parseString(t *testing.T, text string) *Document {
+	doc, err := Parse(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestDiffDocuments(t *testing.T) {
+	old := parseString(t, `# Title
+Intro
+=> old.gmi Notes
+=> same.gmi Old label
+## Gone
+Body
+`)
+	new := parseString(t, `# New title
+Intro
+=> new.gmi Notes
+=> same.gmi New label
+Body
+=> extra.gmi Extra
+`)
+
+	expected := []string{
+		"changed heading",
+		"unchanged text",
+		"retargeted link",
+		"relabelled link",
+		"removed heading",
+		"unchanged text",
+		"inserted link",
+	}
+
+	diffs := DiffDocuments(old, new)
+	if len(diffs) != len(expected) {
+		t.Fatalf("Expected %d differences got %d", len(expected), len(diffs))
+	}
+	for i, d := range diffs {
+		if d.Description() != expected[i] {
+			t.Errorf("Expected %v got %v", expected[i], d.Description())
+		}
+	}
+}
+
+func TestWriteUnifiedDiff(t *testing.T) {
+	old := parseString(t, "a\nb\nc\nd\ne\nf\ng\nh\n=> x.gmi X\n")
+	new := parseString(t, "a\nB\nc\nd\ne\nf\ng\nh\n=> y.gmi X\n")
+
+	expected := `--- old
++++ new
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+@@ -8,2 +8,2 @@
+ h
+-=> x.gmi X
++=> y.gmi X
+`
+
+	var output strings.Builder
+	if err := WriteUnifiedDiff(&output, DiffDocuments(old, new), "old", "new", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestWriteGmiDiff(t *testing.T) {
+	old := parseString(t, "# T\n=> x.gmi X\n")
+	new := parseString(t, "# T\n=> y.gmi X\n* new\n")
+
+	expected := `## Line 2: retargeted link
+> => x.gmi X
+=> y.gmi X
+
+## Line 3: inserted list item
+* new
+`
+
+	var output strings.Builder
+	if err := WriteGmiDiff(&output, DiffDocuments(old, new)); err != nil {
+		t.Fatal(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestWriteGmiDiffUnterminated(t *testing.T) {
+	old := parseString(t, "# T\n")
+	new := parseString(t, "# T\n```\ncode\n")
+	diffs := DiffDocuments(old, new)
+	diffs = append(diffs, Difference{Op: DiffInsert, New: ListItemLine{Text: "after"}})
+
+	expected := "## Line 2: inserted preformatted block\n" +
+		"```\n" +
+		"code\n" +
+		"```\n" +
+		"\n" +
+		"## Line 4: inserted list item\n" +
+		"* after\n"
+
+	var output strings.Builder
+	if err := WriteGmiDiff(&output, diffs); err != nil {
+		t.Fatal(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %q got %q", expected, actual)
+	}
+}
+
+func TestCommonLines(t *testing.T) {
+	// The length of a longest common subsequence, the slow way
+	lcs := func(a, b []int) int {
+		table := make([][]int, len(a)+1)
+		for i := range table {
+			table[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					table[i][j] = table[i+1][j+1] + 1
+				} else if table[i+1][j] > table[i][j+1] {
+					table[i][j] = table[i+1][j]
+				} else {
+					table[i][j] = table[i][j+1]
+				}
+			}
+		}
+		return table[0][0]
+	}
+
+	tests := [][2][]int{
+		{{}, {}},
+		{{1, 2, 3}, {}},
+		{{1, 2, 3}, {1, 2, 3}},
+		{{1, 2, 3, 4, 5}, {1, 9, 3, 4, 5}},
+		{{1, 2, 3, 1, 2, 3}, {3, 2, 1, 3, 2, 1}},
+		{{5, 1, 4, 2, 6, 3, 1}, {1, 2, 3, 4, 5, 1, 6}},
+		{{1, 1, 2, 2, 3, 3, 1}, {2, 1, 3, 1, 2, 1}},
+	}
+	for _, test := range tests {
+		a, b := test[0], test[1]
+		matches := commonLines(a, b)
+		if len(matches) != lcs(a, b) {
+			t.Errorf("%v %v: expected %d matches got %v", a, b, lcs(a, b), matches)
+		}
+		last := [2]int{-1, -1}
+		for _, m := range matches {
+			if m[0] <= last[0] || m[1] <= last[1] || a[m[0]] != b[m[1]] {
+				t.Errorf("%v %v: bad matches %v", a, b, matches)
+				break
+			}
+			last = m
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package gmikit
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// A Line is a single element of a parsed gemtext document. Visit replays the
+// line into a Visitor as the same calls ParseLines would have made, including
+// any source positions recorded when it was parsed.
+type Line interface {
+	Visit(v Visitor) error
+}
+
+type TextLine struct {
+	Text string
+	Pos  Position
+}
+
+type LinkLine struct {
+	Target       *url.URL
+	FriendlyName string
+	Pos          Position
+}
+
+// MalformedLinkLine is a link line whose target couldn't be parsed, kept by a
+// lenient Parser. Err is the error from url.Parse.
+type MalformedLinkLine struct {
+	Target       string
+	FriendlyName string
+	Err          error
+	Pos          Position
+}
+
+type HeadingLine struct {
+	Level int
+	Text  string
+	Pos   Position
+}
+
+type ListItemLine struct {
+	Text string
+	Pos  Position
+}
+
+type QuoteLine struct {
+	Text string
+	Pos  Position
+}
+
+// PreformattedBlock holds everything between a pair of preformatting toggles.
+// Unterminated is set when the document ended before the closing toggle. Pos
+// and EndPos are the positions of the toggles, and LinePos those of Lines.
+type PreformattedBlock struct {
+	AltText      string
+	Lines        []string
+	Unterminated bool
+	Pos          Position
+	LinePos      []Position
+	EndPos       Position
+}
+
+type Document struct {
+	Lines []Line
+}
+
+// visitPosition reports pos to v if v wants it and pos came from a parse.
+func visitPosition(v Visitor, pos Position) error {
+	if pv, ok := v.(PositionedVisitor); ok && pos.Line != 0 {
+		return pv.Position(pos)
+	}
+	return nil
+}
+
+func (l TextLine) Visit(v Visitor) error {
+	if err := visitPosition(v, l.Pos); err != nil {
+		return err
+	}
+	return v.Text(l.Text)
+}
+
+func (l LinkLine) Visit(v Visitor) error {
+	if err := visitPosition(v, l.Pos); err != nil {
+		return err
+	}
+	return v.Link(l.Target, l.FriendlyName)
+}
+
+func (l MalformedLinkLine) Visit(v Visitor) error {
+	if err := visitPosition(v, l.Pos); err != nil {
+		return err
+	}
+	if mv, ok := v.(MalformedLinkVisitor); ok {
+		return mv.MalformedLink(l.Target, l.FriendlyName, l.Err)
+	}
+	if l.FriendlyName == "" {
+		return v.Text("=> " + l.Target)
+	}
+	return v.Text("=> " + l.Target + " " + l.FriendlyName)
+}
+
+func (l HeadingLine) Visit(v Visitor) error {
+	if err := visitPosition(v, l.Pos); err != nil {
+		return err
+	}
+	switch l.Level {
+	case 1:
+		return v.Heading1(l.Text)
+	case 2:
+		return v.Heading2(l.Text)
+	case 3:
+		return v.Heading3(l.Text)
+	default:
+		return fmt.Errorf("invalid heading level %d", l.Level)
+	}
+}
+
+func (l ListItemLine) Visit(v Visitor) error {
+	if err := visitPosition(v, l.Pos); err != nil {
+		return err
+	}
+	return v.UnorderedListItem(l.Text)
+}
+
+func (l QuoteLine) Visit(v Visitor) error {
+	if err := visitPosition(v, l.Pos); err != nil {
+		return err
+	}
+	return v.Quote(l.Text)
+}
+
+func (b PreformattedBlock) Visit(v Visitor) error {
+	if err := visitPosition(v, b.Pos); err != nil {
+		return err
+	}
+	if err := v.PreformattingToggle(b.AltText); err != nil {
+		return err
+	}
+	for i, text := range b.Lines {
+		if i < len(b.LinePos) {
+			if err := visitPosition(v, b.LinePos[i]); err != nil {
+				return err
+			}
+		}
+		if err := v.PreformattedText(text); err != nil {
+			return err
+		}
+	}
+	if b.Unterminated {
+		return nil
+	}
+	if err := visitPosition(v, b.EndPos); err != nil {
+		return err
+	}
+	return v.PreformattingToggle("")
+}
+
+func Parse(r io.Reader) (*Document, error) {
+	var p Parser
+	return p.Parse(r)
+}
+
+func (p *Parser) Parse(r io.Reader) (*Document, error) {
+	b := &documentBuilder{doc: &Document{}}
+	if err := p.ParseLines(r, b); err != nil {
+		return nil, err
+	}
+	return b.doc, nil
+}
+
+// Walk visits every line of doc. Errors from lines which came from a parse
+// are wrapped in a *LineError with the line's position, which for a
+// preformatted block is that of its opening toggle.
+func Walk(doc *Document, v Visitor) error {
+	if err := v.Begin(); err != nil {
+		return err
+	}
+	for _, line := range doc.Lines {
+		if err := line.Visit(v); err != nil {
+			if pos := linePosition(line); pos.Line != 0 {
+				return &LineError{Pos: pos, Err: err}
+			}
+			return err
+		}
+	}
+	return v.End()
+}
+
+// linePosition is where line was parsed from, or the zero Position.
+func linePosition(line Line) Position {
+	switch l := line.(type) {
+	case TextLine:
+		return l.Pos
+	case LinkLine:
+		return l.Pos
+	case MalformedLinkLine:
+		return l.Pos
+	case HeadingLine:
+		return l.Pos
+	case ListItemLine:
+		return l.Pos
+	case QuoteLine:
+		return l.Pos
+	case PreformattedBlock:
+		return l.Pos
+	}
+	return Position{}
+}
+
+type documentBuilder struct {
+	doc *Document
+	pre *PreformattedBlock
+	pos Position
+}
+
+func (b *documentBuilder) add(line Line) error {
+	b.doc.Lines = append(b.doc.Lines, line)
+	return nil
+}
+
+func (b *documentBuilder) Position(pos Position) error {
+	b.pos = pos
+	return nil
+}
+
+func (b *documentBuilder) Begin() error { return nil }
+
+func (b *documentBuilder) End() error {
+	if b.pre != nil {
+		b.pre.Unterminated = true
+		pre := b.pre
+		b.pre = nil
+		return b.add(*pre)
+	}
+	return nil
+}
+
+func (b *documentBuilder) Text(text string) error {
+	return b.add(TextLine{Text: text, Pos: b.pos})
+}
+
+func (b *documentBuilder) Link(target *url.URL, friendlyName string) error {
+	return b.add(LinkLine{
+		Target:       target,
+		FriendlyName: friendlyName,
+		Pos:          b.pos,
+	})
+}
+
+func (b *documentBuilder) MalformedLink(target string, friendlyName string, err error) error {
+	return b.add(MalformedLinkLine{
+		Target:       target,
+		FriendlyName: friendlyName,
+		Err:          err,
+		Pos:          b.pos,
+	})
+}
+
+// Nothing but preformatted text can appear between two toggles, so the block
+// is only added to the document once it is closed.
+func (b *documentBuilder) PreformattingToggle(altText string) error {
+	if b.pre == nil {
+		b.pre = &PreformattedBlock{AltText: altText, Pos: b.pos}
+		return nil
+	}
+	pre := b.pre
+	pre.EndPos = b.pos
+	b.pre = nil
+	return b.add(*pre)
+}
+
+func (b *documentBuilder) PreformattedText(text string) error {
+	b.pre.Lines = append(b.pre.Lines, text)
+	b.pre.LinePos = append(b.pre.LinePos, b.pos)
+	return nil
+}
+
+func (b *documentBuilder) Heading1(text string) error {
+	return b.add(HeadingLine{Level: 1, Text: text, Pos: b.pos})
+}
+
+func (b *documentBuilder) Heading2(text string) error {
+	return b.add(HeadingLine{Level: 2, Text: text, Pos: b.pos})
+}
+
+func (b *documentBuilder) Heading3(text string) error {
+	return b.add(HeadingLine{Level: 3, Text: text, Pos: b.pos})
+}
+
+func (b *documentBuilder) UnorderedListItem(text string) error {
+	return b.add(ListItemLine{Text: text, Pos: b.pos})
+}
+
+func (b *documentBuilder) Quote(text string) error {
+	return b.add(QuoteLine{Text: text, Pos: b.pos})
+}
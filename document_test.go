@@ -0,0 +1,104 @@
+package gmikit
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseWalkRoundtrip(t *testing.T) {
+	expected := `# Heading 1
+
+Text, more text.
+
+=> hello.gmi Hello!
+* One
+> Two
+` + "```alt\n" +
+		"pre\n" +
+		"```\n" +
+		"## Heading 2\n"
+
+	doc, err := Parse(strings.NewReader(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Lines) != 9 {
+		t.Errorf("Expected 9 lines got %d", len(doc.Lines))
+	}
+
+	var output strings.Builder
+	if err := Walk(doc, NewGmiWriter(&output)); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestWalkEdited(t *testing.T) {
+	doc, err := Parse(strings.NewReader("=> hello.gmi Hello!\n```\nunterminated\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	link := doc.Lines[0].(LinkLine)
+	link.Target, _ = url.Parse("goodbye.gmi")
+	doc.Lines[0] = link
+	doc.Lines = append([]Line{HeadingLine{Level: 1, Text: "Title"}}, doc.Lines...)
+
+	if pre := doc.Lines[2].(PreformattedBlock); !pre.Unterminated {
+		t.Errorf("Expected unterminated block")
+	}
+
+	expected := `<h1>Title</h1>
+<a href="goodbye.gmi">Hello!</a><br/>
+<pre>
+unterminated
+</pre>
+`
+
+	var output strings.Builder
+	if err := Walk(doc, NewHtmlWriter(&output, nil)); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+type failingVisitor struct {
+	BaseVisitor
+}
+
+func (failingVisitor) Heading2(text string) error {
+	return errors.New("no second level headings")
+}
+
+func TestWalkLineError(t *testing.T) {
+	doc, err := Parse(strings.NewReader("# One\ntext\n## Two\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Walk(doc, failingVisitor{})
+	var lineErr *LineError
+	if !errors.As(err, &lineErr) {
+		t.Fatalf("Expected LineError got %v", err)
+	}
+	if lineErr.Pos.Line != 3 {
+		t.Errorf("Expected line 3 got %d", lineErr.Pos.Line)
+	}
+
+	// Lines added by hand have no position to report
+	doc.Lines = []Line{HeadingLine{Level: 2, Text: "Added"}}
+	err = Walk(doc, failingVisitor{})
+	if err == nil || errors.As(err, &lineErr) {
+		t.Errorf("Expected a bare error got %v", err)
+	}
+}
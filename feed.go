@@ -0,0 +1,484 @@
+package gmikit
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// A Feed is a list of dated entries, like a gemlog index page following the
+// Gemini subscription convention.
+type Feed struct {
+	Title    string
+	Subtitle string
+	URL      *url.URL
+	Entries  []*FeedEntry
+}
+
+// A FeedEntry is one dated link from a feed. Content is the entry's gemtext,
+// if it has been fetched.
+type FeedEntry struct {
+	Title   string
+	URL     *url.URL
+	Updated time.Time
+	Content string
+}
+
+// FeedBuilder is a Visitor which builds a Feed from a gemtext page, following
+// the Gemini subscription convention. Link lines whose label starts with a
+// YYYY-MM-DD date are entries, the first level 1 heading is the feed's title
+// and a level 2 heading straight after it is the subtitle. Pages without a
+// level 1 heading take their title from the first heading. Entry URLs are
+// resolved against the base URL, if there is one.
+type FeedBuilder struct {
+	BaseVisitor
+	Feed  *Feed
+	base  *url.URL
+	level int
+	lines int
+}
+
+func NewFeedBuilder(base *url.URL) *FeedBuilder {
+	return &FeedBuilder{Feed: &Feed{URL: base}, base: base}
+}
+
+// ParseFeed reads a feed from a gemtext page. Links with malformed targets
+// are skipped.
+func ParseFeed(r io.Reader, base *url.URL) (*Feed, error) {
+	b := NewFeedBuilder(base)
+	p := Parser{Lenient: true}
+	if err := p.ParseLines(r, b); err != nil {
+		return nil, err
+	}
+	return b.Feed, nil
+}
+
+func (b *FeedBuilder) Text(text string) error {
+	if text != "" {
+		b.lines++
+	}
+	return nil
+}
+
+func (b *FeedBuilder) heading(level int, text string) error {
+	b.lines++
+	if b.level == 1 && level == 2 && b.Feed.Subtitle == "" && b.lines == 2 {
+		b.Feed.Subtitle = text
+	}
+	if b.Feed.Title == "" || (level == 1 && b.level != 1) {
+		b.Feed.Title = text
+		b.level = level
+		b.lines = 1
+	}
+	return nil
+}
+
+func (b *FeedBuilder) Heading1(text string) error { return b.heading(1, text) }
+func (b *FeedBuilder) Heading2(text string) error { return b.heading(2, text) }
+func (b *FeedBuilder) Heading3(text string) error { return b.heading(3, text) }
+
+func (b *FeedBuilder) Link(target *url.URL, friendlyName string) error {
+	b.lines++
+	const layout = "2006-01-02"
+	if len(friendlyName) < len(layout) {
+		return nil
+	}
+	date, err := time.Parse(layout, friendlyName[:len(layout)])
+	if err != nil {
+		return nil
+	}
+
+	title := strings.TrimLeft(friendlyName[len(layout):], " \t-–—:|")
+	if title == "" {
+		title = friendlyName[:len(layout)]
+	}
+	if b.base != nil {
+		target = b.base.ResolveReference(target)
+	}
+	b.Feed.Entries = append(b.Feed.Entries, &FeedEntry{
+		Title:   title,
+		URL:     target,
+		Updated: date,
+	})
+	return nil
+}
+
+// MalformedLink skips links whose target can't be parsed, rather than
+// letting one bad line spoil the feed.
+func (b *FeedBuilder) MalformedLink(target string, friendlyName string, err error) error {
+	b.lines++
+	return nil
+}
+
+// Updated is the date of the newest entry in the feed.
+func (f *Feed) Updated() time.Time {
+	var updated time.Time
+	for _, entry := range f.Entries {
+		if entry.Updated.After(updated) {
+			updated = entry.Updated
+		}
+	}
+	return updated
+}
+
+// Fetch fetches the content of each gemini entry in the feed. Entries which
+// can't be fetched are left without content, and the first error is returned
+// once every entry has been tried.
+func (f *Feed) Fetch(client *Client) error {
+	var first error
+	for _, entry := range f.Entries {
+		if entry.URL.Scheme != "gemini" {
+			continue
+		}
+		if err := entry.Fetch(client); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (e *FeedEntry) Fetch(client *Client) error {
+	resp, err := client.Do(NewRequest(e.URL))
+	if err != nil {
+		return fmt.Errorf("%s: %w", e.URL, err)
+	}
+	defer resp.Close()
+
+	if resp.Status.Class() != StatusClassSuccess {
+		return fmt.Errorf("%s: %v", e.URL, resp.Status)
+	}
+	if m, _, err := resp.MediaType(); err != nil || m != "text/gemini" {
+		return fmt.Errorf("%s: not gemtext: %s", e.URL, resp.Meta)
+	}
+
+	body, err := resp.DecodedBody()
+	if err != nil {
+		return fmt.Errorf("%s: %w", e.URL, err)
+	}
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("%s: %w", e.URL, err)
+	}
+	e.Content = string(content)
+	return nil
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Text string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID      string    `xml:"id"`
+	Title   string    `xml:"title"`
+	Updated string    `xml:"updated"`
+	Link    atomLink  `xml:"link"`
+	Content *atomText `xml:"content,omitempty"`
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Author   string      `xml:"author>name"`
+	Link     *atomLink   `xml:"link,omitempty"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+// WriteAtom writes the feed as an Atom document. Fetched content is converted
+// to HTML. The feed's author is the host name of its URL. Atom identifies
+// feeds and entries by their URLs, so these have to be absolute.
+func (f *Feed) WriteAtom(w io.Writer) error {
+	if f.URL == nil || !f.URL.IsAbs() {
+		return fmt.Errorf("atom feeds need an absolute URL, got %v", f.URL)
+	}
+	feed := atomFeed{
+		ID:       f.URL.String(),
+		Title:    f.Title,
+		Subtitle: f.Subtitle,
+		Updated:  f.Updated().UTC().Format(time.RFC3339),
+		Author:   f.URL.Hostname(),
+		Link:     &atomLink{Href: f.URL.String(), Rel: "alternate"},
+	}
+
+	for _, entry := range f.Entries {
+		if !entry.URL.IsAbs() {
+			return fmt.Errorf("atom entries need an absolute URL, got %v", entry.URL)
+		}
+		e := atomEntry{
+			ID:      entry.URL.String(),
+			Title:   entry.Title,
+			Updated: entry.Updated.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: entry.URL.String(), Rel: "alternate"},
+		}
+		if entry.Content != "" {
+			var content strings.Builder
+			err := GmiToHtml(strings.NewReader(entry.Content), &content)
+			if err != nil {
+				return err
+			}
+			e.Content = &atomText{Type: "html", Text: content.String()}
+		}
+		feed.Entries = append(feed.Entries, e)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// GmiToAtom reads a gemlog index page and writes it as an Atom feed, with
+// entry URLs resolved against base, which must be absolute.
+func GmiToAtom(r io.Reader, w io.Writer, base *url.URL) error {
+	feed, err := ParseFeed(r, base)
+	if err != nil {
+		return err
+	}
+	return feed.WriteAtom(w)
+}
+
+// ParseFeedResponse reads a feed from a successful response, which may be a
+// gemtext page or an Atom or RSS document. Links are resolved against base,
+// which should be the URL the response came from. HTML content in Atom and RSS
+// feeds is converted to gemtext.
+func ParseFeedResponse(resp *Response, base *url.URL) (*Feed, error) {
+	m, params, err := resp.MediaType()
+	if err != nil {
+		return nil, err
+	}
+
+	switch m {
+	case "text/gemini":
+		body, err := resp.DecodedBody()
+		if err != nil {
+			return nil, err
+		}
+		return ParseFeed(body, base)
+	case "application/atom+xml", "application/rss+xml",
+		"application/xml", "text/xml":
+		// A charset given in the response overrides the XML declaration,
+		// which is otherwise left to the XML decoder
+		charset, ok := params["charset"]
+		if !ok {
+			return parseXMLFeed(resp.Body, base, false)
+		}
+		body, err := NewUTF8Reader(resp.Body, charset)
+		if err != nil {
+			return nil, err
+		}
+		return parseXMLFeed(body, base, true)
+	default:
+		return nil, fmt.Errorf("not a feed: %s", resp.Meta)
+	}
+}
+
+type xmlLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Text string `xml:",chardata"`
+}
+
+type xmlText struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+// xmlFeed holds the parts of both Atom and RSS documents that make up a Feed.
+// Atom feeds put everything in the feed element, while RSS feeds put
+// everything in a channel.
+type xmlFeed struct {
+	XMLName  xml.Name
+	Title    string    `xml:"title"`
+	Subtitle string    `xml:"subtitle"`
+	Links    []xmlLink `xml:"link"`
+	Entries  []struct {
+		Title     string    `xml:"title"`
+		Links     []xmlLink `xml:"link"`
+		Updated   string    `xml:"updated"`
+		Published string    `xml:"published"`
+		Content   xmlText   `xml:"content"`
+		Summary   xmlText   `xml:"summary"`
+	} `xml:"entry"`
+
+	Channel struct {
+		Title       string    `xml:"title"`
+		Description string    `xml:"description"`
+		Links       []xmlLink `xml:"link"`
+		Items       []struct {
+			Title       string `xml:"title"`
+			Link        string `xml:"link"`
+			GUID        string `xml:"guid"`
+			PubDate     string `xml:"pubDate"`
+			Description string `xml:"description"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+// parseXMLFeed reads an Atom or RSS document. If decoded is set, r has
+// already been converted to UTF-8, whatever encoding the document declares.
+func parseXMLFeed(r io.Reader, base *url.URL, decoded bool) (*Feed, error) {
+	var doc xmlFeed
+	dec := xml.NewDecoder(r)
+	dec.CharsetReader = func(charset string, r io.Reader) (io.Reader, error) {
+		if decoded {
+			return r, nil
+		}
+		return NewUTF8Reader(r, charset)
+	}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	feed := &Feed{URL: base}
+	resolve := func(href string) (*url.URL, error) {
+		target, err := url.Parse(strings.TrimSpace(href))
+		if err != nil {
+			return nil, err
+		}
+		if base != nil {
+			target = base.ResolveReference(target)
+		}
+		return target, nil
+	}
+
+	switch doc.XMLName.Local {
+	case "feed":
+		feed.Title = strings.TrimSpace(doc.Title)
+		feed.Subtitle = strings.TrimSpace(doc.Subtitle)
+		if href := xmlAlternate(doc.Links); href != "" {
+			target, err := resolve(href)
+			if err != nil {
+				return nil, err
+			}
+			feed.URL = target
+		}
+
+		for _, e := range doc.Entries {
+			target, err := resolve(xmlAlternate(e.Links))
+			if err != nil {
+				return nil, err
+			}
+			date := e.Updated
+			if date == "" {
+				date = e.Published
+			}
+			content := e.Content
+			if content.Text == "" {
+				content = e.Summary
+			}
+			entry := &FeedEntry{
+				Title:   strings.TrimSpace(e.Title),
+				URL:     target,
+				Updated: parseFeedDate(date),
+			}
+			if entry.Content, err = xmlContent(content.Type, content.Text); err != nil {
+				return nil, err
+			}
+			feed.Entries = append(feed.Entries, entry)
+		}
+	case "rss":
+		feed.Title = strings.TrimSpace(doc.Channel.Title)
+		feed.Subtitle = strings.TrimSpace(doc.Channel.Description)
+		for _, link := range doc.Channel.Links {
+			// Skip namespaced links, like atom:link, which have no text
+			if href := strings.TrimSpace(link.Text); href != "" {
+				target, err := resolve(href)
+				if err != nil {
+					return nil, err
+				}
+				feed.URL = target
+				break
+			}
+		}
+
+		for _, item := range doc.Channel.Items {
+			href := item.Link
+			if href == "" {
+				href = item.GUID
+			}
+			target, err := resolve(href)
+			if err != nil {
+				return nil, err
+			}
+			entry := &FeedEntry{
+				Title:   strings.TrimSpace(item.Title),
+				URL:     target,
+				Updated: parseFeedDate(item.PubDate),
+			}
+			if entry.Content, err = xmlContent("html", item.Description); err != nil {
+				return nil, err
+			}
+			feed.Entries = append(feed.Entries, entry)
+		}
+	default:
+		return nil, fmt.Errorf("not a feed: <%s>", doc.XMLName.Local)
+	}
+	return feed, nil
+}
+
+// xmlAlternate finds the link to the web page for an Atom feed or entry.
+func xmlAlternate(links []xmlLink) string {
+	for _, link := range links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+// xmlContent converts the content of an Atom or RSS entry to gemtext.
+func xmlContent(kind string, content string) (string, error) {
+	if strings.TrimSpace(content) == "" {
+		return "", nil
+	}
+	if kind != "html" {
+		return content, nil
+	}
+
+	var b strings.Builder
+	if err := HtmlToGmi(strings.NewReader(content), NewGmiWriter(&b)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+var feedDateLayouts = []string{
+	time.RFC3339,
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2006-01-02",
+}
+
+// parseFeedDate parses the dates found in Atom and RSS feeds, which are
+// often not quite in the format they should be. Dates that can't be parsed
+// are left as the zero time.
+func parseFeedDate(date string) time.Time {
+	date = strings.TrimSpace(date)
+	for _, layout := range feedDateLayouts {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,212 @@
+package gmikit
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGmiToAtom(t *testing.T) {
+	input := strings.NewReader(`# My gemlog
+Some introduction.
+=> /about.gmi About me
+=> 2021-04-02-second.gmi 2021-04-02 - Second post
+=> gemini://other.example/first.gmi 2021-03-01 First & foremost
+`)
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <id>gemini://example.org/gemlog/</id>
+  <title>My gemlog</title>
+  <updated>2021-04-02T00:00:00Z</updated>
+  <author>
+    <name>example.org</name>
+  </author>
+  <link href="gemini://example.org/gemlog/" rel="alternate"></link>
+  <entry>
+    <id>gemini://example.org/gemlog/2021-04-02-second.gmi</id>
+    <title>Second post</title>
+    <updated>2021-04-02T00:00:00Z</updated>
+    <link href="gemini://example.org/gemlog/2021-04-02-second.gmi" rel="alternate"></link>
+  </entry>
+  <entry>
+    <id>gemini://other.example/first.gmi</id>
+    <title>First &amp; foremost</title>
+    <updated>2021-03-01T00:00:00Z</updated>
+    <link href="gemini://other.example/first.gmi" rel="alternate"></link>
+  </entry>
+</feed>
+`
+
+	base, _ := url.Parse("gemini://example.org/gemlog/")
+	var output strings.Builder
+	if err := GmiToAtom(input, &output, base); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestAtomContent(t *testing.T) {
+	base, _ := url.Parse("gemini://example.org/")
+	feed := &Feed{Title: "Feed", URL: base}
+	target, _ := url.Parse("gemini://example.org/a.gmi")
+	feed.Entries = append(feed.Entries, &FeedEntry{
+		Title:   "A",
+		URL:     target,
+		Content: "# A\n",
+	})
+
+	var output strings.Builder
+	if err := feed.WriteAtom(&output); err != nil {
+		t.Error(err)
+	}
+
+	expected := `<content type="html">&lt;h1&gt;A&lt;/h1&gt;&#xA;</content>`
+	if !strings.Contains(output.String(), expected) {
+		t.Errorf("Expected content %v in %v", expected, output.String())
+	}
+}
+
+func TestGmiToAtomNeedsAbsoluteURLs(t *testing.T) {
+	input := "# Log\n=> a.gmi 2021-04-02 A\n"
+	relative, _ := url.Parse("/gemlog/")
+
+	for _, base := range []*url.URL{nil, relative} {
+		var output strings.Builder
+		if err := GmiToAtom(strings.NewReader(input), &output, base); err == nil {
+			t.Errorf("Expected error for base %v", base)
+		}
+		if output.Len() != 0 {
+			t.Errorf("Expected no output for base %v got %q", base, output.String())
+		}
+	}
+}
+
+func TestParseFeed(t *testing.T) {
+	input := strings.NewReader(`# Gemlog
+## Thoughts and things
+
+=> 2021-04-02.gmi 2021-04-02 Second
+=> notes.gmi Not an entry
+=> %zz 2021-03-15 Malformed
+=> /first.gmi 2021-03-01: First
+`)
+
+	base, _ := url.Parse("gemini://example.org/log/")
+	feed, err := ParseFeed(input, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if feed.Title != "Gemlog" || feed.Subtitle != "Thoughts and things" {
+		t.Errorf("Expected title and subtitle, got %q %q", feed.Title, feed.Subtitle)
+	}
+	expected := []string{
+		"2021-04-02 Second gemini://example.org/log/2021-04-02.gmi",
+		"2021-03-01 First gemini://example.org/first.gmi",
+	}
+	if len(feed.Entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %d", len(expected), len(feed.Entries))
+	}
+	for i, entry := range feed.Entries {
+		actual := entry.Updated.Format("2006-01-02") + " " + entry.Title + " " + entry.URL.String()
+		if expected[i] != actual {
+			t.Errorf("Expected %v got %v", expected[i], actual)
+		}
+	}
+}
+
+func TestParseFeedResponse(t *testing.T) {
+	atom := `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Atom feed</title>
+  <subtitle>About things</subtitle>
+  <link href="/" rel="alternate"/>
+  <link href="/atom.xml" rel="self"/>
+  <entry>
+    <title>Post</title>
+    <link href="post.html"/>
+    <updated>2021-04-02T10:00:00Z</updated>
+    <content type="html">&lt;p&gt;Hello &lt;a href="x.html"&gt;there&lt;/a&gt;&lt;/p&gt;</content>
+  </entry>
+</feed>`
+
+	rss := `<?xml version="1.0" encoding="ISO-8859-1"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+  <channel>
+    <title>RSS feed</title>
+    <description>About things</description>
+    <atom:link href="https://example.org/rss.xml" rel="self"/>
+    <link>https://example.org/</link>
+    <item>
+      <title>Post</title>
+      <link>https://example.org/post.html</link>
+      <pubDate>Fri, 02 Apr 2021 10:00:00 +0000</pubDate>
+      <description>&lt;p&gt;Hello &lt;a href="x.html"&gt;there&lt;/a&gt;&lt;/p&gt;</description>
+    </item>
+  </channel>
+</rss>`
+
+	base, _ := url.Parse("https://example.org/feed")
+	for _, tc := range []struct {
+		meta  string
+		body  string
+		title string
+	}{
+		{"application/atom+xml", atom, "Atom feed"},
+		{"application/rss+xml", rss, "RSS feed"},
+	} {
+		resp := &Response{
+			Status: StatusSuccess,
+			Meta:   tc.meta,
+			Body:   strings.NewReader(tc.body),
+		}
+		feed, err := ParseFeedResponse(resp, base)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if feed.Title != tc.title || feed.Subtitle != "About things" {
+			t.Errorf("Expected title and subtitle, got %q %q", feed.Title, feed.Subtitle)
+		}
+		if feed.URL.String() != "https://example.org/" {
+			t.Errorf("Expected feed URL, got %v", feed.URL)
+		}
+		if len(feed.Entries) != 1 {
+			t.Fatalf("Expected 1 entry, got %d", len(feed.Entries))
+		}
+		entry := feed.Entries[0]
+		if entry.Title != "Post" ||
+			entry.URL.String() != "https://example.org/post.html" ||
+			!entry.Updated.Equal(time.Date(2021, 4, 2, 10, 0, 0, 0, time.UTC)) {
+			t.Errorf("Unexpected entry %v %v %v", entry.Title, entry.URL, entry.Updated)
+		}
+		content := "Hello there\n=> x.html there\n"
+		if entry.Content != content {
+			t.Errorf("Expected content %q got %q", content, entry.Content)
+		}
+	}
+}
+
+func TestParseFeedResponseCharset(t *testing.T) {
+	atom := "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n" +
+		"<feed xmlns=\"http://www.w3.org/2005/Atom\"><title>Caf\xe9</title></feed>"
+
+	resp := &Response{
+		Status: StatusSuccess,
+		Meta:   "application/atom+xml; charset=iso-8859-1",
+		Body:   strings.NewReader(atom),
+	}
+	feed, err := ParseFeedResponse(resp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Title != "Café" {
+		t.Errorf("Expected title %q got %q", "Café", feed.Title)
+	}
+}
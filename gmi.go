@@ -1,7 +1,6 @@
 package gmikit
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"net/url"
@@ -22,77 +21,142 @@ type Visitor interface {
 	Quote(text string) error
 }
 
+// Position identifies where in the source a line came from. Line is
+// 1-based, Offset is the byte offset of the start of the line, and Raw is the
+// line as it appeared before any normalization, without its line ending.
+// Newline is the line ending, which is empty for the last line of a document
+// without a final newline, or for a piece of a split line.
+type Position struct {
+	Line    int
+	Offset  int64
+	Raw     string
+	Newline string
+}
+
+const bom = "\ufeff"
+
+// A MalformedLinkVisitor is given the link lines a lenient Parser couldn't
+// parse a target URL for, along with the error from url.Parse.
+type MalformedLinkVisitor interface {
+	Visitor
+	MalformedLink(target string, friendlyName string, err error) error
+}
+
+// A PositionedVisitor is told the position of each line before the Visitor
+// method for that line is called.
+type PositionedVisitor interface {
+	Visitor
+	Position(pos Position) error
+}
+
+// LineError wraps an error encountered while handling a particular line.
+type LineError struct {
+	Pos Position
+	Err error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Pos.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// LongLinePolicy decides what the parser does with lines longer than its
+// MaxLineLength.
+type LongLinePolicy int
+
+const (
+	// LongLineFail stops parsing with an *ErrLineTooLong.
+	LongLineFail LongLinePolicy = iota
+	// LongLineTruncate drops everything past the limit.
+	LongLineTruncate
+	// LongLineSplit breaks the line into several lines no longer than the
+	// limit. The pieces after the first are treated as plain or
+	// preformatted text.
+	LongLineSplit
+)
+
+const DefaultMaxLineLength = 64 * 1024
+
+type ErrLineTooLong struct {
+	Line int
+	Max  int
+}
+
+func (e *ErrLineTooLong) Error() string {
+	return fmt.Sprintf("line %d: longer than %d bytes", e.Line, e.Max)
+}
+
+type Parser struct {
+	// MaxLineLength is the length in bytes of the longest line accepted,
+	// not counting the line ending. Zero means DefaultMaxLineLength, and a
+	// negative value means no limit.
+	MaxLineLength int
+	LongLines     LongLinePolicy
+
+	// Lenient keeps going when a link target can't be parsed. The link is
+	// passed to the visitor's MalformedLink method if it has one, or else
+	// is treated as a text line.
+	Lenient bool
+
+	Mode ParseMode
+}
+
+type ParseMode int
+
+const (
+	// ParseLegacy is forgiving about the syntax of line types. Any line
+	// starting with "*" is a list item, and "####" starts a level 3 heading.
+	ParseLegacy ParseMode = iota
+	// ParseStrict follows the gemtext specification to the letter. List
+	// items need "* ", headings have at most three "#", link lines without a
+	// URL are text, and text after a closing preformatting toggle is
+	// ignored.
+	ParseStrict
+)
+
+const ws = " \t"
+
 func ParseLines(r io.Reader, v Visitor) error {
-	const ws = " \t"
+	var p Parser
+	return p.ParseLines(r, v)
+}
+
+func (p *Parser) ParseLines(r io.Reader, v Visitor) error {
+	pv, _ := v.(PositionedVisitor)
 	pre := false
-	scanner := bufio.NewScanner(r)
+	scanner, lines := p.newScanner(r)
 	if err := v.Begin(); err != nil {
 		return err
 	}
 	for scanner.Scan() {
-		text := scanner.Text()
-		if strings.HasPrefix(text, "```") {
-			pre = !pre
-			if err := v.PreformattingToggle(text[3:]); err != nil {
-				return err
-			}
-		} else if pre {
-			if err := v.PreformattedText(text); err != nil {
-				return err
-			}
-		} else if strings.HasPrefix(text, "=>") {
-			text = text[2:]
-			text = strings.TrimLeft(text, ws)
-			split := strings.IndexAny(text, ws)
-			if split == -1 {
-				url, err := url.Parse(text)
-				if err != nil {
-					return err
-				}
-
-				if err = v.Link(url, ""); err != nil {
-					return err
-				}
-			} else {
-				url, err := url.Parse(text[:split])
-				if err != nil {
-					return err
-				}
-
-				name := strings.TrimLeft(text[split:], ws)
-				if err = v.Link(url, name); err != nil {
-					return err
-				}
-			}
-		} else if strings.HasPrefix(text, "*") {
-			text = strings.TrimLeft(text[1:], ws)
-			if err := v.UnorderedListItem(text); err != nil {
-				return err
-			}
-		} else if strings.HasPrefix(text, "###") {
-			text = strings.TrimLeft(text[3:], ws)
-			if err := v.Heading3(text); err != nil {
-				return err
-			}
-		} else if strings.HasPrefix(text, "##") {
-			text = strings.TrimLeft(text[2:], ws)
-			if err := v.Heading2(text); err != nil {
-				return err
-			}
-		} else if strings.HasPrefix(text, "#") {
-			text = strings.TrimLeft(text[1:], ws)
-			if err := v.Heading1(text); err != nil {
-				return err
+		pos := Position{
+			Line:    lines.line,
+			Offset:  lines.start,
+			Raw:     scanner.Text(),
+			Newline: lines.newline,
+		}
+		if pv != nil {
+			if err := pv.Position(pos); err != nil {
+				return &LineError{Pos: pos, Err: err}
 			}
-		} else if strings.HasPrefix(text, ">") {
-			text = strings.TrimLeft(text[1:], ws)
-			if err := v.Quote(text); err != nil {
-				return err
+		}
+		var err error
+		if !lines.continued {
+			text := pos.Raw
+			if pos.Offset == 0 {
+				text = strings.TrimPrefix(text, bom)
 			}
+			err = p.parseLine(text, &pre, v)
+		} else if pre {
+			err = v.PreformattedText(pos.Raw)
 		} else {
-			if err := v.Text(text); err != nil {
-				return err
-			}
+			err = v.Text(pos.Raw)
+		}
+		if err != nil {
+			return &LineError{Pos: pos, Err: err}
 		}
 	}
 	if err := v.End(); err != nil {
@@ -102,9 +166,154 @@ func ParseLines(r io.Reader, v Visitor) error {
 	return scanner.Err()
 }
 
+func (p *Parser) parseLine(text string, pre *bool, v Visitor) error {
+	if p.Mode == ParseStrict {
+		return p.parseStrictLine(text, pre, v)
+	}
+
+	if strings.HasPrefix(text, "```") {
+		*pre = !*pre
+		return v.PreformattingToggle(text[3:])
+	} else if *pre {
+		return v.PreformattedText(text)
+	} else if strings.HasPrefix(text, "=>") {
+		return p.parseLink(text, v)
+	} else if strings.HasPrefix(text, "*") {
+		text = strings.TrimLeft(text[1:], ws)
+		return v.UnorderedListItem(text)
+	} else if strings.HasPrefix(text, "###") {
+		text = strings.TrimLeft(text[3:], ws)
+		return v.Heading3(text)
+	} else if strings.HasPrefix(text, "##") {
+		text = strings.TrimLeft(text[2:], ws)
+		return v.Heading2(text)
+	} else if strings.HasPrefix(text, "#") {
+		text = strings.TrimLeft(text[1:], ws)
+		return v.Heading1(text)
+	} else if strings.HasPrefix(text, ">") {
+		text = strings.TrimLeft(text[1:], ws)
+		return v.Quote(text)
+	} else {
+		return v.Text(text)
+	}
+}
+
+func (p *Parser) parseStrictLine(text string, pre *bool, v Visitor) error {
+	if strings.HasPrefix(text, "```") {
+		*pre = !*pre
+		if !*pre {
+			return v.PreformattingToggle("")
+		}
+		return v.PreformattingToggle(text[3:])
+	} else if *pre {
+		return v.PreformattedText(text)
+	} else if strings.HasPrefix(text, "=>") {
+		if strings.TrimLeft(text[2:], ws) == "" {
+			return v.Text(text)
+		}
+		return p.parseLink(text, v)
+	} else if strings.HasPrefix(text, "* ") {
+		return v.UnorderedListItem(text[2:])
+	} else if strings.HasPrefix(text, "####") {
+		return v.Text(text)
+	} else if strings.HasPrefix(text, "###") {
+		return v.Heading3(strings.TrimLeft(text[3:], ws))
+	} else if strings.HasPrefix(text, "##") {
+		return v.Heading2(strings.TrimLeft(text[2:], ws))
+	} else if strings.HasPrefix(text, "#") {
+		return v.Heading1(strings.TrimLeft(text[1:], ws))
+	} else if strings.HasPrefix(text, ">") {
+		return v.Quote(strings.TrimLeft(text[1:], ws))
+	} else {
+		return v.Text(text)
+	}
+}
+
+// splitLink splits a link line into its target and friendly name, as they
+// were written.
+func splitLink(line string) (string, string) {
+	text := strings.TrimLeft(strings.TrimPrefix(line, "=>"), ws)
+	if split := strings.IndexAny(text, ws); split != -1 {
+		return text[:split], strings.TrimLeft(text[split:], ws)
+	}
+	return text, ""
+}
+
+func (p *Parser) parseLink(line string, v Visitor) error {
+	target, name := splitLink(line)
+	url, err := url.Parse(target)
+	if err != nil {
+		if !p.Lenient {
+			return err
+		}
+		if mv, ok := v.(MalformedLinkVisitor); ok {
+			return mv.MalformedLink(target, name, err)
+		}
+		return v.Text(line)
+	}
+
+	return v.Link(url, name)
+}
+
+// escapeText makes sure a line of converted text is read back as text, by
+// putting a space in front of it if it starts like another type of line.
+func escapeText(text string) string {
+	for _, prefix := range []string{"=>", "*", "#", ">", "```"} {
+		if strings.HasPrefix(text, prefix) {
+			return " " + text
+		}
+	}
+	return text
+}
+
+// escapePreformatted makes sure a line of converted preformatted text isn't
+// read back as the toggle which ends the block.
+func escapePreformatted(text string) string {
+	if strings.HasPrefix(text, "```") {
+		return " " + text
+	}
+	return text
+}
+
+// RepairURL makes a best effort at parsing a link target url.Parse rejected,
+// by escaping stray percent signs and characters that aren't allowed in URLs.
+func RepairURL(target string) (*url.URL, error) {
+	const hex = "0123456789abcdefABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(target); i++ {
+		c := target[i]
+		switch {
+		case c == '%':
+			if i+2 < len(target) &&
+				strings.IndexByte(hex, target[i+1]) != -1 &&
+				strings.IndexByte(hex, target[i+2]) != -1 {
+				b.WriteByte(c)
+			} else {
+				b.WriteString("%25")
+			}
+		case c <= ' ' || c == 0x7f || c == '"' || c == '<' || c == '>' ||
+			c == '\\' || c == '^' || c == '`' || c == '{' || c == '|' ||
+			c == '}':
+			fmt.Fprintf(&b, "%%%02X", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return url.Parse(b.String())
+}
+
+// GmiWriter writes normalized gemtext. With Lossless set, it uses the
+// positions reported by the parser to write each line exactly as it appeared
+// in the source, unless the line has since been changed to mean something
+// else. Line endings, a byte order mark and a missing final newline are kept
+// too.
 type GmiWriter struct {
-	w   io.Writer
-	pre bool
+	w        io.Writer
+	pre      bool
+	Lossless bool
+	pos      Position
+	newline  string
+	last     Position
 }
 
 func NewGmiWriter(w io.Writer) *GmiWriter {
@@ -114,63 +323,121 @@ func NewGmiWriter(w io.Writer) *GmiWriter {
 func (g *GmiWriter) Begin() error { return nil }
 func (g *GmiWriter) End() error   { return nil }
 
-func (g *GmiWriter) Text(text string) error {
-	_, err := fmt.Fprintf(g.w, "%s\n", text)
+func (g *GmiWriter) Position(pos Position) error {
+	g.pos = pos
+	return nil
+}
+
+func (g *GmiWriter) writeLine(text string, pre bool) error {
+	pos := g.pos
+	g.pos = Position{}
+	if !g.Lossless {
+		_, err := fmt.Fprintf(g.w, "%s\n", text)
+		return err
+	}
+
+	if g.newline == "" {
+		g.newline = "\n"
+	}
+	if g.last.Line != 0 && g.last.Newline == "" &&
+		pos.Offset != g.last.Offset+int64(len(g.last.Raw)) {
+		// The last line didn't end in a newline, but it's no longer last
+		if _, err := io.WriteString(g.w, g.newline); err != nil {
+			return err
+		}
+	}
+
+	newline := g.newline
+	if pos.Line != 0 {
+		newline = pos.Newline
+		if newline != "" {
+			g.newline = newline
+		}
+
+		if g.unchanged(pos.Raw, text, pre) {
+			text = pos.Raw
+		} else if pos.Offset == 0 && strings.HasPrefix(pos.Raw, bom) {
+			text = bom + text
+		}
+	}
+	g.last = pos
+
+	_, err := fmt.Fprintf(g.w, "%s%s", text, newline)
 	return err
 }
 
+// unchanged checks whether raw would be written as text if it were parsed
+// again.
+func (g *GmiWriter) unchanged(raw string, text string, pre bool) bool {
+	var normal strings.Builder
+	p := Parser{Lenient: true}
+	err := p.parseLine(strings.TrimPrefix(raw, bom), &pre, &GmiWriter{
+		w:   &normal,
+		pre: pre,
+	})
+	return err == nil && normal.String() == text+"\n"
+}
+
+func (g *GmiWriter) Text(text string) error {
+	return g.writeLine(text, g.pre)
+}
+
 func (g *GmiWriter) Link(target *url.URL, friendlyName string) error {
+	return g.MalformedLink(target.String(), friendlyName, nil)
+}
+
+func (g *GmiWriter) MalformedLink(target string, friendlyName string, _ error) error {
 	if friendlyName == "" {
-		_, err := fmt.Fprintf(g.w, "=> %s\n", target)
-		return err
+		return g.writeLine(fmt.Sprintf("=> %s", target), g.pre)
 	} else {
-		_, err := fmt.Fprintf(g.w, "=> %s %s\n", target, friendlyName)
-		return err
+		return g.writeLine(fmt.Sprintf("=> %s %s", target, friendlyName), g.pre)
 	}
 }
 
 func (g *GmiWriter) PreformattingToggle(altText string) error {
 	if g.pre {
 		g.pre = false
-		_, err := fmt.Fprintf(g.w, "```\n")
-		return err
+		return g.writeLine("```", true)
 	} else {
 		g.pre = true
-		_, err := fmt.Fprintf(g.w, "```%s\n", altText)
-		return err
+		return g.writeLine("```"+altText, false)
 	}
 }
 
 func (g *GmiWriter) PreformattedText(text string) error {
-	_, err := fmt.Fprintf(g.w, "%s\n", text)
-	return err
+	return g.writeLine(text, g.pre)
 }
 
 func (g *GmiWriter) Heading1(text string) error {
-	_, err := fmt.Fprintf(g.w, "# %s\n", text)
-	return err
+	return g.writeLine("# "+text, g.pre)
 }
 
 func (g *GmiWriter) Heading2(text string) error {
-	_, err := fmt.Fprintf(g.w, "## %s\n", text)
-	return err
+	return g.writeLine("## "+text, g.pre)
 }
 
 func (g *GmiWriter) Heading3(text string) error {
-	_, err := fmt.Fprintf(g.w, "### %s\n", text)
-	return err
+	return g.writeLine("### "+text, g.pre)
 }
 
 func (g *GmiWriter) UnorderedListItem(text string) error {
-	_, err := fmt.Fprintf(g.w, "* %s\n", text)
-	return err
+	return g.writeLine("* "+text, g.pre)
 }
 
 func (g *GmiWriter) Quote(text string) error {
-	_, err := fmt.Fprintf(g.w, "> %s\n", text)
-	return err
+	return g.writeLine("> "+text, g.pre)
 }
 
 func NormalizeGmi(r io.Reader, w io.Writer) error {
 	return ParseLines(r, NewGmiWriter(w))
 }
+
+// RoundtripGmi copies a gemtext document through the parser and a lossless
+// GmiWriter, which should leave it unchanged. Links with malformed targets
+// are kept as they are.
+func RoundtripGmi(r io.Reader, w io.Writer) error {
+	g := NewGmiWriter(w)
+	g.Lossless = true
+	p := Parser{Lenient: true}
+	return p.ParseLines(r, g)
+}
@@ -1,6 +1,7 @@
 package gmikit
 
 import (
+	"errors"
 	"strings"
 	"testing"
 )
@@ -42,3 +43,242 @@ Text, more text.
 		t.Errorf("Expected %v got %v", expected, actual)
 	}
 }
+
+type positionRecorder struct {
+	GmiWriter
+	positions []Position
+}
+
+func (p *positionRecorder) Position(pos Position) error {
+	p.positions = append(p.positions, pos)
+	return nil
+}
+
+func TestPositions(t *testing.T) {
+	input := strings.NewReader("# Title\r\n\n=>  hello.gmi\tHello!\n")
+	var output strings.Builder
+	p := &positionRecorder{GmiWriter: *NewGmiWriter(&output)}
+	if err := ParseLines(input, p); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Position{
+		{Line: 1, Offset: 0, Raw: "# Title", Newline: "\r\n"},
+		{Line: 2, Offset: 9, Raw: "", Newline: "\n"},
+		{Line: 3, Offset: 10, Raw: "=>  hello.gmi\tHello!", Newline: "\n"},
+	}
+	if len(p.positions) != len(expected) {
+		t.Fatalf("Expected %v got %v", expected, p.positions)
+	}
+	for i := range expected {
+		if expected[i] != p.positions[i] {
+			t.Errorf("Expected %v got %v", expected[i], p.positions[i])
+		}
+	}
+}
+
+func TestLineError(t *testing.T) {
+	input := strings.NewReader("# Title\n=> %zz Broken\n")
+	var output strings.Builder
+	err := NormalizeGmi(input, &output)
+
+	var lineErr *LineError
+	if !errors.As(err, &lineErr) {
+		t.Fatalf("Expected LineError got %v", err)
+	}
+	if lineErr.Pos.Line != 2 || lineErr.Pos.Offset != 8 {
+		t.Errorf("Expected line 2 offset 8 got %v", lineErr.Pos)
+	}
+}
+
+func TestLongLines(t *testing.T) {
+	input := "# Hi\n" + strings.Repeat("x", 10) + "\n```\n" + strings.Repeat("y", 7) + "\n```\n"
+	tests := []struct {
+		policy   LongLinePolicy
+		expected string
+	}{
+		{LongLineTruncate, "# Hi\nxxxx\n```\nyyyy\n```\n"},
+		{LongLineSplit, "# Hi\nxxxx\nxxxx\nxx\n```\nyyyy\nyyy\n```\n"},
+	}
+
+	for _, test := range tests {
+		p := Parser{MaxLineLength: 4, LongLines: test.policy}
+		var output strings.Builder
+		if err := p.ParseLines(strings.NewReader(input), NewGmiWriter(&output)); err != nil {
+			t.Error(err)
+		}
+
+		actual := output.String()
+		if test.expected != actual {
+			t.Errorf("Expected %v got %v", test.expected, actual)
+		}
+	}
+}
+
+func TestLineTooLong(t *testing.T) {
+	input := strings.NewReader("# Title\n\n" + strings.Repeat("x", DefaultMaxLineLength+1) + "\n")
+	var output strings.Builder
+	err := NormalizeGmi(input, &output)
+
+	var tooLong *ErrLineTooLong
+	if !errors.As(err, &tooLong) {
+		t.Fatalf("Expected ErrLineTooLong got %v", err)
+	}
+	if tooLong.Line != 3 {
+		t.Errorf("Expected line 3 got %d", tooLong.Line)
+	}
+	if expected := "# Title\n\n"; output.String() != expected {
+		t.Errorf("Expected %v got %v", expected, output.String())
+	}
+}
+
+func TestLenientLinks(t *testing.T) {
+	expected := `# Title
+=> 100%.gmi Done
+=> %zz
+After
+`
+
+	p := Parser{Lenient: true}
+	var output strings.Builder
+	if err := p.ParseLines(strings.NewReader(expected), NewGmiWriter(&output)); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestRoundtripLossless(t *testing.T) {
+	expected := "\ufeff#Title\r\n" +
+		"=>foo.gmi\tFoo\r\n" +
+		"=> 100%25.gmi  Done\r\n" +
+		"=> %zz bad\r\n" +
+		"*item\r\n" +
+		"```alt\r\n" +
+		"  pre\r\n" +
+		"```trailing\r\n" +
+		">quote"
+
+	input := strings.NewReader(expected)
+	var output strings.Builder
+	if err := RoundtripGmi(input, &output); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %q got %q", expected, actual)
+	}
+}
+
+func TestLosslessEdited(t *testing.T) {
+	doc, err := Parse(strings.NewReader("\ufeff#Title\r\n=>foo.gmi\tFoo\r\n*item"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	heading := doc.Lines[0].(HeadingLine)
+	heading.Text = "New title"
+	doc.Lines[0] = heading
+	doc.Lines = append(doc.Lines, QuoteLine{Text: "Added"})
+
+	expected := "\ufeff# New title\r\n=>foo.gmi\tFoo\r\n*item\r\n> Added\r\n"
+
+	var output strings.Builder
+	g := NewGmiWriter(&output)
+	g.Lossless = true
+	if err := Walk(doc, g); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %q got %q", expected, actual)
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	input := "*emphasis*\n" +
+		"* item\n" +
+		"#### Not a heading\n" +
+		"###Heading\n" +
+		"=>  \n" +
+		"```alt\n" +
+		"pre\n" +
+		"```ignored\n"
+
+	tests := []struct {
+		mode     ParseMode
+		expected string
+	}{
+		{ParseLegacy, "* emphasis*\n" +
+			"* item\n" +
+			"### # Not a heading\n" +
+			"### Heading\n" +
+			"=> \n" +
+			"```alt\n" +
+			"pre\n" +
+			"```\n"},
+		{ParseStrict, "*emphasis*\n" +
+			"* item\n" +
+			"#### Not a heading\n" +
+			"### Heading\n" +
+			"=>  \n" +
+			"```alt\n" +
+			"pre\n" +
+			"```\n"},
+	}
+
+	for _, test := range tests {
+		p := Parser{Mode: test.mode}
+		doc, err := p.Parse(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var output strings.Builder
+		if err := Walk(doc, NewGmiWriter(&output)); err != nil {
+			t.Error(err)
+		}
+
+		actual := output.String()
+		if test.expected != actual {
+			t.Errorf("Expected %q got %q", test.expected, actual)
+		}
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	tests := []string{
+		"=> nope",
+		"* not a list",
+		"*emphasis*",
+		"# not a heading",
+		"> not a quote",
+		"```not pre",
+		"plain",
+	}
+
+	for _, text := range tests {
+		for _, mode := range []ParseMode{ParseLegacy, ParseStrict} {
+			p := Parser{Mode: mode}
+			doc, err := p.Parse(strings.NewReader(escapeText(text) + "\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(doc.Lines) != 1 {
+				t.Fatalf("Expected 1 line for %q got %d", text, len(doc.Lines))
+			}
+			if _, ok := doc.Lines[0].(TextLine); !ok {
+				t.Errorf("Expected %q to be text got %T", text, doc.Lines[0])
+			}
+		}
+	}
+
+	if actual := escapeText("plain"); actual != "plain" {
+		t.Errorf("Expected plain text unchanged got %q", actual)
+	}
+}
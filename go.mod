@@ -8,4 +8,7 @@ require (
 	github.com/pelletier/go-toml v1.8.1
 	github.com/spf13/pflag v1.0.5
 	github.com/urfave/negroni v1.0.0
+	github.com/yuin/goldmark v1.3.5
+	golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4
+	golang.org/x/text v0.3.6
 )
@@ -0,0 +1,196 @@
+package gmikit
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// GophermapWriter writes gemtext as a gopher menu for the hole at Base, a
+// gopher URL. Text, headings and quotes become info lines wrapped to Width
+// columns, and preformatted lines are broken at Width. Gopher links keep
+// their item type, links to other schemes become "h" items with a "URL:"
+// selector, and relative links point into the same hole, with an item type
+// guessed from the file extension.
+type GophermapWriter struct {
+	w     io.Writer
+	Base  *url.URL
+	Width int
+}
+
+const DefaultGopherWidth = 70
+
+func NewGophermapWriter(w io.Writer, base *url.URL) *GophermapWriter {
+	return &GophermapWriter{w: w, Base: base, Width: DefaultGopherWidth}
+}
+
+func (g *GophermapWriter) hostPort() (string, string) {
+	port := g.Base.Port()
+	if port == "" {
+		port = "70"
+	}
+	return g.Base.Hostname(), port
+}
+
+func (g *GophermapWriter) item(kind byte, display string, selector string, host string, port string) error {
+	_, err := fmt.Fprintf(g.w, "%c%s\t%s\t%s\t%s\r\n",
+		kind, gopherField(display), gopherField(selector), host, port)
+	return err
+}
+
+func (g *GophermapWriter) info(lines ...string) error {
+	host, port := g.hostPort()
+	for _, line := range lines {
+		if err := g.item('i', line, "", host, port); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *GophermapWriter) Begin() error { return nil }
+func (g *GophermapWriter) End() error   { return nil }
+
+func (g *GophermapWriter) Text(text string) error {
+	return g.info(wrap(text, g.Width, "", "")...)
+}
+
+func (g *GophermapWriter) Link(target *url.URL, friendlyName string) error {
+	if friendlyName == "" {
+		friendlyName = target.String()
+	}
+
+	switch {
+	case target.Scheme == "gopher":
+		port := target.Port()
+		if port == "" {
+			port = "70"
+		}
+		kind, selector := byte('1'), ""
+		if len(target.Path) > 1 {
+			kind, selector = target.Path[1], target.Path[2:]
+		}
+		return g.item(kind, friendlyName, selector, target.Hostname(), port)
+	case target.Scheme == "" && target.Host == "":
+		selector := gopherSelector(g.Base)
+		if !strings.HasSuffix(selector, "/") {
+			selector = path.Dir(selector) + "/"
+		}
+		dir := &url.URL{Path: selector}
+		local := dir.ResolveReference(&url.URL{Path: target.Path})
+		host, port := g.hostPort()
+		return g.item(gopherType(local.Path), friendlyName, local.Path, host, port)
+	default:
+		host, port := g.hostPort()
+		if target.Scheme == "" {
+			// A network-path reference, which keeps our scheme
+			target = g.Base.ResolveReference(target)
+		}
+		return g.item('h', friendlyName, "URL:"+target.String(), host, port)
+	}
+}
+
+func (g *GophermapWriter) PreformattingToggle(altText string) error {
+	return nil
+}
+
+func (g *GophermapWriter) PreformattedText(text string) error {
+	text = expandTabs(text)
+	if g.Width <= 0 || utf8.RuneCountInString(text) <= g.Width {
+		return g.info(text)
+	}
+
+	var lines []string
+	for utf8.RuneCountInString(text) > g.Width {
+		i := 0
+		for n := 0; n < g.Width; n++ {
+			_, size := utf8.DecodeRuneInString(text[i:])
+			i += size
+		}
+		lines = append(lines, text[:i])
+		text = text[i:]
+	}
+	return g.info(append(lines, text)...)
+}
+
+func (g *GophermapWriter) heading(underline string, text string) error {
+	lines := wrap(text, g.Width, "", "")
+	if underline != "" {
+		length := 0
+		for _, line := range lines {
+			if n := utf8.RuneCountInString(line); n > length {
+				length = n
+			}
+		}
+		lines = append(lines, strings.Repeat(underline, length))
+	}
+	return g.info(lines...)
+}
+
+func (g *GophermapWriter) Heading1(text string) error { return g.heading("=", text) }
+func (g *GophermapWriter) Heading2(text string) error { return g.heading("-", text) }
+func (g *GophermapWriter) Heading3(text string) error { return g.heading("", text) }
+
+func (g *GophermapWriter) UnorderedListItem(text string) error {
+	return g.info(wrap(text, g.Width, "* ", "  ")...)
+}
+
+func (g *GophermapWriter) Quote(text string) error {
+	return g.info(wrap(text, g.Width, "> ", "> ")...)
+}
+
+// gopherField replaces the characters which would break up a menu line.
+func gopherField(text string) string {
+	return strings.NewReplacer("\t", " ", "\r", "", "\n", " ").Replace(text)
+}
+
+func expandTabs(text string) string {
+	if !strings.Contains(text, "\t") {
+		return text
+	}
+	var b strings.Builder
+	col := 0
+	for _, r := range text {
+		if r == '\t' {
+			n := 8 - col%8
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+			continue
+		}
+		b.WriteRune(r)
+		col++
+	}
+	return b.String()
+}
+
+// gopherSelector takes the item type off the path of a gopher URL.
+func gopherSelector(u *url.URL) string {
+	if len(u.Path) < 2 {
+		return "/"
+	}
+	return u.Path[2:]
+}
+
+// gopherType guesses the item type of a file in the hole from its name.
+func gopherType(name string) byte {
+	if strings.HasSuffix(name, "/") {
+		return '1'
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case "", ".txt", ".gmi", ".gemini", ".md":
+		return '0'
+	case ".gif":
+		return 'g'
+	case ".png", ".jpg", ".jpeg", ".webp", ".bmp":
+		return 'I'
+	case ".html", ".htm":
+		return 'h'
+	case ".wav", ".mp3", ".ogg", ".flac":
+		return 's'
+	default:
+		return '9'
+	}
+}
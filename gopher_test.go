@@ -0,0 +1,48 @@
+package gmikit
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGophermapWriter(t *testing.T) {
+	input := strings.NewReader("# Hole\n" +
+		"Some text\twith a tab.\n" +
+		"=> gopher://other.example/0/about.txt About\n" +
+		"=> gopher://other.example:7070 Other hole\n" +
+		"=> gemini://example.org/ Capsule\n" +
+		"=> posts/ Posts\n" +
+		"=> /images/cat.png\n" +
+		"> Quoted\n" +
+		"```\n" +
+		"0123456789abcdef\n" +
+		"```\n")
+
+	expected := "iHole\t\texample.org\t70\r\n" +
+		"i====\t\texample.org\t70\r\n" +
+		"iSome text\t\texample.org\t70\r\n" +
+		"iwith a\t\texample.org\t70\r\n" +
+		"itab.\t\texample.org\t70\r\n" +
+		"0About\t/about.txt\tother.example\t70\r\n" +
+		"1Other hole\t\tother.example\t7070\r\n" +
+		"hCapsule\tURL:gemini://example.org/\texample.org\t70\r\n" +
+		"1Posts\t/phlog/posts/\texample.org\t70\r\n" +
+		"I/images/cat.png\t/images/cat.png\texample.org\t70\r\n" +
+		"i> Quoted\t\texample.org\t70\r\n" +
+		"i0123456789\t\texample.org\t70\r\n" +
+		"iabcdef\t\texample.org\t70\r\n"
+
+	base, _ := url.Parse("gopher://example.org/1/phlog/")
+	var output strings.Builder
+	g := NewGophermapWriter(&output, base)
+	g.Width = 10
+	if err := ParseLines(input, g); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %q got %q", expected, actual)
+	}
+}
@@ -5,6 +5,11 @@ import (
 	"html/template"
 	"io"
 	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 type UrlRewriter func(*url.URL) (*url.URL, string, error)
@@ -24,6 +29,11 @@ type HtmlWriter struct {
 	w        io.Writer
 	Rewriter UrlRewriter
 	elem     element
+
+	// HeadingIDs gives each heading an id attribute, which is the same as
+	// the ID of the heading in the document's Outline.
+	HeadingIDs bool
+	slugs      Slugger
 }
 
 func NewHtmlWriter(w io.Writer, rewriter UrlRewriter) *HtmlWriter {
@@ -118,6 +128,28 @@ func (h *HtmlWriter) Link(target *url.URL, friendlyName string) error {
 	})
 }
 
+var malformedLink = template.Must(
+	template.New("malformedLink").Parse(
+		"<span class=\"malformed\">{{.}}</span><br/>\n"))
+
+// MalformedLink links to a repaired version of the target if it can, and
+// otherwise shows the link as plain text.
+func (h *HtmlWriter) MalformedLink(target string, friendlyName string, _ error) error {
+	if url, err := RepairURL(target); err == nil {
+		return h.Link(url, friendlyName)
+	}
+
+	err := h.Clear()
+	if err != nil {
+		return err
+	}
+
+	if friendlyName == "" {
+		friendlyName = target
+	}
+	return malformedLink.Execute(h.w, friendlyName)
+}
+
 var altPre = template.Must(
 	template.New("altPre").
 		Parse(`<div aria-label="{{.}}">
@@ -152,41 +184,45 @@ func (h *HtmlWriter) PreformattedText(text string) error {
 
 var h1 = template.Must(
 	template.New("h1").
-		Parse("<h1>{{.}}</h1>\n"))
+		Parse("<h1{{ if .ID }} id=\"{{.ID}}\"{{ end }}>{{.Text}}</h1>\n"))
 
 func (h *HtmlWriter) Heading1(text string) error {
-	err := h.Clear()
-	if err != nil {
-		return err
-	}
-
-	return h1.Execute(h.w, text)
+	return h.heading(h1, text)
 }
 
 var h2 = template.Must(
 	template.New("h2").
-		Parse("<h2>{{.}}</h2>\n"))
+		Parse("<h2{{ if .ID }} id=\"{{.ID}}\"{{ end }}>{{.Text}}</h2>\n"))
 
 func (h *HtmlWriter) Heading2(text string) error {
-	err := h.Clear()
-	if err != nil {
-		return err
-	}
-
-	return h2.Execute(h.w, text)
+	return h.heading(h2, text)
 }
 
 var h3 = template.Must(
 	template.New("h3").
-		Parse("<h3>{{.}}</h3>\n"))
+		Parse("<h3{{ if .ID }} id=\"{{.ID}}\"{{ end }}>{{.Text}}</h3>\n"))
 
 func (h *HtmlWriter) Heading3(text string) error {
+	return h.heading(h3, text)
+}
+
+func (h *HtmlWriter) heading(tmpl *template.Template, text string) error {
 	err := h.Clear()
 	if err != nil {
 		return err
 	}
 
-	return h3.Execute(h.w, text)
+	id := ""
+	if h.HeadingIDs {
+		id = h.slugs.Slug(text)
+	}
+	return tmpl.Execute(h.w, struct {
+		ID   string
+		Text string
+	}{
+		ID:   id,
+		Text: text,
+	})
 }
 
 var li = template.Must(
@@ -229,3 +265,408 @@ func (h *HtmlWriter) Quote(text string) error {
 func GmiToHtml(r io.Reader, w io.Writer) error {
 	return ParseLines(r, NewHtmlWriter(w, nil))
 }
+
+// htmlInline lists the elements which don't break up the text around them.
+// Anything else starts a new line.
+var htmlInline = map[atom.Atom]bool{
+	atom.A: true, atom.Abbr: true, atom.B: true, atom.Bdi: true,
+	atom.Bdo: true, atom.Cite: true, atom.Code: true, atom.Data: true,
+	atom.Del: true, atom.Dfn: true, atom.Em: true, atom.Font: true,
+	atom.I: true, atom.Ins: true, atom.Kbd: true, atom.Label: true,
+	atom.Mark: true, atom.Q: true, atom.S: true, atom.Samp: true,
+	atom.Small: true, atom.Span: true, atom.Strong: true, atom.Sub: true,
+	atom.Sup: true, atom.Time: true, atom.U: true, atom.Var: true,
+}
+
+// htmlDropped lists the elements whose content is never shown.
+var htmlDropped = map[atom.Atom]bool{
+	atom.Head: true, atom.Script: true, atom.Style: true,
+	atom.Template: true, atom.Noscript: true, atom.Iframe: true,
+	atom.Object: true, atom.Svg: true, atom.Button: true, atom.Select: true,
+	atom.Textarea: true,
+}
+
+type htmlImporter struct {
+	v     Visitor
+	text  strings.Builder
+	links []markdownLink
+	last  string
+	kind  string
+	item  string
+	depth int
+}
+
+// HtmlToGmi converts an HTML document to gemtext, passing the lines to v.
+// Headings, paragraphs, lists, blockquotes and preformatted text become their
+// gemtext equivalents, and links and images become link lines following the
+// block they appear in. Scripts, styles and the document head are dropped.
+func HtmlToGmi(r io.Reader, v Visitor) error {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return err
+	}
+
+	h := &htmlImporter{v: v, kind: "text"}
+	if err := v.Begin(); err != nil {
+		return err
+	}
+	if err := h.children(doc); err != nil {
+		return err
+	}
+	if err := h.flush(); err != nil {
+		return err
+	}
+	if err := h.flushLinks(); err != nil {
+		return err
+	}
+	return v.End()
+}
+
+// emit passes on a line of the given kind, separating it from the line
+// before with a blank line if they belong to different blocks.
+func (h *htmlImporter) emit(kind string, f func() error) error {
+	continues := kind == "link" || kind == "line"
+	separate := kind != h.last || kind == "text" || kind == "heading" ||
+		kind == "pre"
+	if h.last != "" && !continues && separate {
+		if err := h.v.Text(""); err != nil {
+			return err
+		}
+	}
+	h.last = kind
+	return f()
+}
+
+// flush passes on the text gathered so far as lines of the current kind.
+func (h *htmlImporter) flush() error {
+	text := h.text.String()
+	h.text.Reset()
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	switch h.kind {
+	case "quote":
+		for _, line := range lines {
+			if err := h.emit("quote", func() error { return h.v.Quote(line) }); err != nil {
+				return err
+			}
+		}
+	case "list":
+		line := h.item + strings.Join(lines, " ")
+		h.item = ""
+		if err := h.emit("list", func() error { return h.v.UnorderedListItem(line) }); err != nil {
+			return err
+		}
+	default:
+		for i, line := range lines {
+			kind := "text"
+			if i > 0 {
+				// Lines broken by <br> belong to the same paragraph
+				kind = "line"
+			}
+			line = escapeText(line)
+			if err := h.emit(kind, func() error { return h.v.Text(line) }); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (h *htmlImporter) flushLinks() error {
+	if h.depth > 0 {
+		// Wait until the list or quote is done
+		return nil
+	}
+	links := h.links
+	h.links = nil
+	for _, link := range links {
+		link := link
+		err := h.emit("link", func() error {
+			target, err := url.Parse(link.target)
+			if err != nil {
+				line := MalformedLinkLine{
+					Target:       link.target,
+					FriendlyName: link.label,
+					Err:          err,
+				}
+				return line.Visit(h.v)
+			}
+			return h.v.Link(target, link.label)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *htmlImporter) children(n *html.Node) error {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if err := h.node(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// block handles an element which stands apart from the text around it, and
+// whose content is shown as the given kind of line.
+func (h *htmlImporter) block(n *html.Node, kind string) error {
+	if err := h.flush(); err != nil {
+		return err
+	}
+	outer := h.kind
+	h.kind = kind
+	if err := h.children(n); err != nil {
+		return err
+	}
+	if err := h.flush(); err != nil {
+		return err
+	}
+	h.kind = outer
+	return h.flushLinks()
+}
+
+func (h *htmlImporter) node(n *html.Node) error {
+	switch n.Type {
+	case html.TextNode:
+		h.text.WriteString(strings.Replace(n.Data, "\n", " ", -1))
+		return nil
+	case html.ElementNode:
+	default:
+		return h.children(n)
+	}
+
+	if htmlDropped[n.DataAtom] {
+		return nil
+	}
+	if htmlInline[n.DataAtom] {
+		if err := h.children(n); err != nil {
+			return err
+		}
+		if n.DataAtom == atom.A {
+			if href := htmlAttr(n, "href"); href != "" {
+				h.links = append(h.links, markdownLink{
+					target: href,
+					label:  strings.Join(strings.Fields(htmlText(n)), " "),
+				})
+			}
+		}
+		return nil
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		h.text.WriteString("\n")
+		return nil
+	case atom.Img:
+		alt := htmlAttr(n, "alt")
+		h.text.WriteString(alt)
+		if src := htmlAttr(n, "src"); src != "" {
+			h.links = append(h.links, markdownLink{target: src, label: alt})
+		}
+		return nil
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		return h.heading(n)
+	case atom.Pre:
+		return h.pre(n)
+	case atom.Hr:
+		if err := h.flush(); err != nil {
+			return err
+		}
+		return h.emit("text", func() error { return h.v.Text("---") })
+	case atom.Ul, atom.Ol:
+		return h.list(n)
+	case atom.Blockquote:
+		h.depth++
+		err := h.block(n, "quote")
+		h.depth--
+		if err != nil {
+			return err
+		}
+		return h.flushLinks()
+	case atom.Li:
+		return h.block(n, h.kind)
+	case atom.Td, atom.Th:
+		h.text.WriteString(" | ")
+		return h.children(n)
+	case atom.Tr:
+		if err := h.flush(); err != nil {
+			return err
+		}
+		if err := h.children(n); err != nil {
+			return err
+		}
+		row := strings.Join(strings.Fields(h.text.String()), " ")
+		h.text.Reset()
+		row = escapeText(strings.TrimPrefix(row, "| "))
+		if row == "" {
+			return nil
+		}
+		return h.emit("row", func() error { return h.v.Text(row) })
+	}
+	return h.block(n, h.kind)
+}
+
+func (h *htmlImporter) heading(n *html.Node) error {
+	if err := h.flush(); err != nil {
+		return err
+	}
+	text := strings.Join(strings.Fields(htmlText(n)), " ")
+	h.anchors(n)
+	if text != "" {
+		err := h.emit("heading", func() error {
+			switch n.DataAtom {
+			case atom.H1:
+				return h.v.Heading1(text)
+			case atom.H2:
+				return h.v.Heading2(text)
+			default:
+				return h.v.Heading3(text)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return h.flushLinks()
+}
+
+func (h *htmlImporter) pre(n *html.Node) error {
+	if err := h.flush(); err != nil {
+		return err
+	}
+
+	// Code blocks are often marked up as <pre><code class="language-go">
+	alt := ""
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom != atom.Code {
+			continue
+		}
+		for _, class := range strings.Fields(htmlAttr(c, "class")) {
+			if strings.HasPrefix(class, "language-") {
+				alt = strings.TrimPrefix(class, "language-")
+			}
+		}
+	}
+
+	text := strings.TrimSuffix(htmlText(n), "\n")
+	h.anchors(n)
+	// A newline right after <pre> is already dropped by the HTML parser
+	err := h.emit("pre", func() error {
+		if err := h.v.PreformattingToggle(alt); err != nil {
+			return err
+		}
+		for _, line := range strings.Split(text, "\n") {
+			if err := h.v.PreformattedText(escapePreformatted(line)); err != nil {
+				return err
+			}
+		}
+		return h.v.PreformattingToggle("")
+	})
+	if err != nil {
+		return err
+	}
+	return h.flushLinks()
+}
+
+// anchors records the links and images inside n, for elements whose text is
+// taken all at once by htmlText rather than walked by node.
+func (h *htmlImporter) anchors(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || htmlDropped[c.DataAtom] {
+			continue
+		}
+		h.anchors(c)
+		switch c.DataAtom {
+		case atom.A:
+			if href := htmlAttr(c, "href"); href != "" {
+				h.links = append(h.links, markdownLink{
+					target: href,
+					label:  strings.Join(strings.Fields(htmlText(c)), " "),
+				})
+			}
+		case atom.Img:
+			if src := htmlAttr(c, "src"); src != "" {
+				h.links = append(h.links, markdownLink{
+					target: src,
+					label:  htmlAttr(c, "alt"),
+				})
+			}
+		}
+	}
+}
+
+func (h *htmlImporter) list(n *html.Node) error {
+	if err := h.flush(); err != nil {
+		return err
+	}
+	outer := h.kind
+	h.kind = "list"
+	h.depth++
+
+	number := 1
+	if start, err := strconv.Atoi(htmlAttr(n, "start")); err == nil {
+		number = start
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom != atom.Li {
+			if err := h.node(c); err != nil {
+				return err
+			}
+			continue
+		}
+		if n.DataAtom == atom.Ol {
+			h.item = strconv.Itoa(number) + ". "
+			number++
+		}
+		if err := h.block(c, "list"); err != nil {
+			return err
+		}
+		h.item = ""
+	}
+
+	h.depth--
+	h.kind = outer
+	return h.flushLinks()
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// htmlText is all of the text inside n, including inline images' alt text.
+func htmlText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			b.WriteString(n.Data)
+		case n.DataAtom == atom.Img:
+			b.WriteString(htmlAttr(n, "alt"))
+		case n.DataAtom == atom.Br:
+			b.WriteString("\n")
+		case n.Type == html.ElementNode && htmlDropped[n.DataAtom]:
+		default:
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	return b.String()
+}
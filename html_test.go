@@ -66,3 +66,181 @@ tested
 		t.Errorf("Expected %v got %v", expected, actual)
 	}
 }
+
+func TestConvertMalformedLinks(t *testing.T) {
+	input := strings.NewReader("=> 100%.gmi Done\n=> http://[::1 Broken\nAfter\n")
+
+	expected := `<a href="100%25.gmi">Done</a><br/>
+<span class="malformed">Broken</span><br/>
+<p>After
+</p>
+`
+
+	p := Parser{Lenient: true}
+	var output strings.Builder
+	if err := p.ParseLines(input, NewHtmlWriter(&output, nil)); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestConvertHeadingIDs(t *testing.T) {
+	input := strings.NewReader("# Title\n## Section\n## Section\n")
+
+	expected := `<h1 id="title">Title</h1>
+<h2 id="section">Section</h2>
+<h2 id="section-2">Section</h2>
+`
+
+	var output strings.Builder
+	h := NewHtmlWriter(&output, nil)
+	h.HeadingIDs = true
+	if err := ParseLines(input, h); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestHtmlToGmi(t *testing.T) {
+	input := strings.NewReader(`<!DOCTYPE html>
+<html>
+<head><title>Ignored</title><style>p { color: red; }</style></head>
+<body>
+<script>alert("hi");</script>
+<h1>Title</h1>
+<p>Some <em>text</em> with <a href="https://example.org/">a link</a>.<br>
+Second line.</p>
+<h4>Deep</h4>
+<ul>
+  <li>One
+    <ol start="3"><li>Three</li><li>Four</li></ol>
+  </li>
+  <li><a href="two.html">Two</a></li>
+</ul>
+<blockquote><p>Quoted</p><p>More</p></blockquote>
+<pre><code class="language-go">func main() {
+	fmt.Println("hi")
+}
+</code></pre>
+<table><tr><th>a</th><th>b</th></tr><tr><td>1</td><td>2</td></tr></table>
+</body>
+</html>`)
+
+	expected := "# Title\n" +
+		"\n" +
+		"Some text with a link.\n" +
+		"Second line.\n" +
+		"=> https://example.org/ a link\n" +
+		"\n" +
+		"### Deep\n" +
+		"\n" +
+		"* One\n" +
+		"* 3. Three\n" +
+		"* 4. Four\n" +
+		"* Two\n" +
+		"=> two.html Two\n" +
+		"\n" +
+		"> Quoted\n" +
+		"> More\n" +
+		"\n" +
+		"```go\n" +
+		"func main() {\n" +
+		"\tfmt.Println(\"hi\")\n" +
+		"}\n" +
+		"```\n" +
+		"\n" +
+		"a | b\n" +
+		"1 | 2\n"
+
+	var output strings.Builder
+	if err := HtmlToGmi(input, NewGmiWriter(&output)); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestHtmlToGmiHeadingAndPreLinks(t *testing.T) {
+	input := strings.NewReader(`<h2><a href="/x">Title</a></h2>
+<pre>see <a href="/y">y</a>
+and <img src="z.png" alt="z"></pre>
+<p>After</p>`)
+
+	expected := "## Title\n" +
+		"=> /x Title\n" +
+		"\n" +
+		"```\n" +
+		"see y\n" +
+		"and z\n" +
+		"```\n" +
+		"=> /y y\n" +
+		"=> z.png z\n" +
+		"\n" +
+		"After\n"
+
+	var output strings.Builder
+	if err := HtmlToGmi(input, NewGmiWriter(&output)); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestHtmlToGmiEscapesText(t *testing.T) {
+	input := strings.NewReader(`<p>* not a list</p>
+<p>=> nope</p>
+<p>First<br># second</p>`)
+
+	expected := " * not a list\n" +
+		"\n" +
+		" => nope\n" +
+		"\n" +
+		"First\n" +
+		" # second\n"
+
+	var output strings.Builder
+	if err := HtmlToGmi(input, NewGmiWriter(&output)); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %q got %q", expected, actual)
+	}
+}
+
+func TestHtmlToGmiPreToggle(t *testing.T) {
+	input := strings.NewReader("<pre>```\n# x\n```</pre><p>after</p>")
+
+	expected := "```\n" +
+		" ```\n" +
+		"# x\n" +
+		" ```\n" +
+		"```\n" +
+		"\n" +
+		"after\n"
+
+	var output strings.Builder
+	if err := HtmlToGmi(input, NewGmiWriter(&output)); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %q got %q", expected, actual)
+	}
+}
@@ -0,0 +1,364 @@
+package gmikit
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUnknownIdentity = errors.New("gemini: unknown identity")
+
+// KeyType is the kind of key a generated identity uses.
+type KeyType int
+
+const (
+	KeyEd25519 KeyType = iota
+	KeyEcdsa
+	KeyRsa
+)
+
+// GenerateCertificate makes a self-signed client certificate for commonName,
+// valid from now for lifetime.
+func GenerateCertificate(commonName string, keyType KeyType, lifetime time.Duration) (tls.Certificate, error) {
+	var key crypto.Signer
+	var err error
+	switch keyType {
+	case KeyEd25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case KeyEcdsa:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyRsa:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		err = fmt.Errorf("unknown key type %d", keyType)
+	}
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// WriteCertificatePem writes a certificate and its private key in PEM format,
+// as read by tls.X509KeyPair.
+func WriteCertificatePem(w io.Writer, cert tls.Certificate) error {
+	for _, der := range cert.Certificate {
+		if err := pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+			return err
+		}
+	}
+	if cert.PrivateKey == nil {
+		return nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return err
+	}
+	return pem.Encode(w, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// An Identity is a named client certificate, and the URL prefixes it is used
+// for.
+type Identity struct {
+	Name        string
+	Certificate tls.Certificate
+	Prefixes    []string
+}
+
+// IdentityStore is a directory of client certificates, each saved as a pair
+// of files NAME.crt and NAME.key, with the URL prefixes they are assigned to
+// listed in a file named "assignments". A prefix is a host and path, like
+// "example.org/private/", and matches that path and anything under it.
+// IdentityStore is safe to use from several goroutines.
+type IdentityStore struct {
+	dir         string
+	identities  map[string]*Identity
+	assignments map[string]string
+	mu          sync.Mutex
+}
+
+const assignmentsFile = "assignments"
+
+// LoadIdentities reads the identities in a directory, which doesn't have to
+// exist yet.
+func LoadIdentities(dir string) (*IdentityStore, error) {
+	s := &IdentityStore{
+		dir:         dir,
+		identities:  make(map[string]*Identity),
+		assignments: make(map[string]string),
+	}
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.crt"))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		cert, err := tls.LoadX509KeyPair(name, strings.TrimSuffix(name, ".crt")+".key")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		id := strings.TrimSuffix(filepath.Base(name), ".crt")
+		s.identities[id] = &Identity{Name: id, Certificate: cert}
+	}
+
+	f, err := os.Open(filepath.Join(dir, assignmentsFile))
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s: line %d: expected prefix and identity", f.Name(), line)
+		}
+		if err := s.assign(fields[0], fields[1]); err != nil {
+			return nil, fmt.Errorf("%s: line %d: %w", f.Name(), line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Identities lists the identities in the store, sorted by name.
+func (s *IdentityStore) Identities() []*Identity {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]*Identity, 0, len(s.identities))
+	for _, id := range s.identities {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].Name < ids[j].Name
+	})
+	return ids
+}
+
+// Get finds an identity by name.
+func (s *IdentityStore) Get(name string) (*Identity, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.identities[name]
+	return id, ok
+}
+
+// Add saves a certificate as a new identity.
+func (s *IdentityStore) Add(name string, cert tls.Certificate) (*Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if name == "" || strings.ContainsAny(name, "/\\ \t") || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("invalid identity name \"%s\"", name)
+	}
+	if _, ok := s.identities[name]; ok {
+		return nil, fmt.Errorf("identity \"%s\" already exists", name)
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	var certPem, keyPem strings.Builder
+	if err := WriteCertificatePem(&certPem, tls.Certificate{Certificate: cert.Certificate}); err != nil {
+		return nil, err
+	}
+	if err := WriteCertificatePem(&keyPem, tls.Certificate{PrivateKey: cert.PrivateKey}); err != nil {
+		return nil, err
+	}
+	base := filepath.Join(s.dir, name)
+	if err := ioutil.WriteFile(base+".key", []byte(keyPem.String()), 0o600); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(base+".crt", []byte(certPem.String()), 0o644); err != nil {
+		return nil, err
+	}
+
+	id := &Identity{Name: name, Certificate: cert}
+	s.identities[name] = id
+	return id, nil
+}
+
+// Assign uses the named identity for every URL under prefix, replacing any
+// identity already assigned to it, and saves the change.
+func (s *IdentityStore) Assign(prefix string, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.assign(prefix, name); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+// Unassign stops using an identity for prefix, and saves the change.
+func (s *IdentityStore) Unassign(prefix string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, err := identityPrefix(prefix)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.assignments[key]; !ok {
+		return nil
+	}
+	s.unassign(key)
+	return s.save()
+}
+
+func (s *IdentityStore) unassign(key string) {
+	id := s.identities[s.assignments[key]]
+	delete(s.assignments, key)
+	for i, p := range id.Prefixes {
+		if p == key {
+			id.Prefixes = append(id.Prefixes[:i], id.Prefixes[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *IdentityStore) assign(prefix string, name string) error {
+	id, ok := s.identities[name]
+	if !ok {
+		return fmt.Errorf("%w \"%s\"", ErrUnknownIdentity, name)
+	}
+	key, err := identityPrefix(prefix)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.assignments[key]; ok {
+		s.unassign(key)
+	}
+	s.assignments[key] = name
+	id.Prefixes = append(id.Prefixes, key)
+	sort.Strings(id.Prefixes)
+	return nil
+}
+
+func (s *IdentityStore) save() error {
+	prefixes := make([]string, 0, len(s.assignments))
+	for prefix := range s.assignments {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	var b strings.Builder
+	for _, prefix := range prefixes {
+		fmt.Fprintf(&b, "%s %s\n", prefix, s.assignments[prefix])
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(s.dir, assignmentsFile), []byte(b.String()), 0o644)
+}
+
+// ForURL finds the identity assigned to the longest prefix of a URL, if any.
+func (s *IdentityStore) ForURL(u *url.URL) (*Identity, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	key := knownHostKey(u.Host) + path
+
+	best := ""
+	for prefix := range s.assignments {
+		if len(prefix) > len(best) && prefixMatches(prefix, key) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return nil, false
+	}
+	return s.identities[s.assignments[best]], true
+}
+
+// Certificate is the certificate to use for a URL, or nil if there is none.
+// It can be used as a Client's Certificate.
+func (s *IdentityStore) Certificate(u *url.URL) *tls.Certificate {
+	if id, ok := s.ForURL(u); ok {
+		return &id.Certificate
+	}
+	return nil
+}
+
+// prefixMatches is whether key is prefix, or under it in the path.
+func prefixMatches(prefix, key string) bool {
+	if !strings.HasPrefix(key, prefix) {
+		return false
+	}
+	return len(key) == len(prefix) || strings.HasSuffix(prefix, "/") || key[len(prefix)] == '/'
+}
+
+// identityPrefix normalizes a prefix, which may be written as a URL or as a
+// host and path.
+func identityPrefix(prefix string) (string, error) {
+	if !strings.Contains(prefix, "://") {
+		prefix = "gemini://" + prefix
+	}
+	u, err := url.Parse(prefix)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "gemini" || u.Host == "" {
+		return "", fmt.Errorf("invalid identity prefix \"%s\"", prefix)
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return knownHostKey(u.Host) + path, nil
+}
@@ -0,0 +1,131 @@
+package gmikit
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGenerateCertificate(t *testing.T) {
+	for _, keyType := range []KeyType{KeyEd25519, KeyEcdsa, KeyRsa} {
+		cert, err := GenerateCertificate("alice", keyType, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert.Leaf.Subject.CommonName != "alice" {
+			t.Errorf("Expected alice got %s", cert.Leaf.Subject.CommonName)
+		}
+		if lifetime := time.Until(cert.Leaf.NotAfter); lifetime > time.Hour || lifetime < 59*time.Minute {
+			t.Errorf("Expected an hour left got %v", lifetime)
+		}
+	}
+}
+
+func TestIdentityStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gmikit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := LoadIdentities(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alice", "bob"} {
+		cert, err := GenerateCertificate(name, KeyEd25519, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Add(name, cert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Assign("example.org/private", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Assign("gemini://example.org:1965/private/bob/", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Assign("example.org/", "carol"); err == nil {
+		t.Error("Expected unknown identity to be refused")
+	}
+
+	store, err = LoadIdentities(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for target, expected := range map[string]string{
+		"gemini://example.org/private":            "alice",
+		"gemini://EXAMPLE.org/private/page.gmi":   "alice",
+		"gemini://example.org/private/bob/":       "bob",
+		"gemini://example.org:1965/private/bob/x": "bob",
+		"gemini://example.org/privateer":          "",
+		"gemini://example.org/":                   "",
+		"gemini://example.org:1966/private":       "",
+	} {
+		u, _ := url.Parse(target)
+		actual := ""
+		if id, ok := store.ForURL(u); ok {
+			actual = id.Name
+		}
+		if expected != actual {
+			t.Errorf("Expected %q for %s got %q", expected, target, actual)
+		}
+	}
+
+	if err := store.Unassign("example.org/private/bob/"); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("gemini://example.org/private/bob/")
+	if id, _ := store.ForURL(u); id == nil || id.Name != "alice" {
+		t.Errorf("Expected alice after unassigning bob got %v", id)
+	}
+}
+
+func TestClientIdentity(t *testing.T) {
+	_, serverCert := testAuthority(t)
+	server := newTestServer(t, serverCert, func(req string, state tls.ConnectionState) string {
+		if len(state.PeerCertificates) == 0 {
+			return "60 Certificate required\r\n"
+		}
+		return "20 text/plain\r\n" + state.PeerCertificates[0].Subject.CommonName
+	})
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "gmikit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := LoadIdentities(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := GenerateCertificate("alice", KeyEcdsa, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Add("alice", cert); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{Certificate: store.Certificate}
+	if body, err := testGet(client, server.request(t, "localhost")); err != nil {
+		t.Fatal(err)
+	} else if body != "60 CLIENT CERTIFICATE REQUIRED" {
+		t.Errorf("Expected certificate to be required got %v", body)
+	}
+
+	if err := store.Assign("localhost:"+server.Port(), "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if body, err := testGet(client, server.request(t, "localhost")); err != nil {
+		t.Fatal(err)
+	} else if body != "alice" {
+		t.Errorf("Expected alice got %v", body)
+	}
+}
@@ -0,0 +1,194 @@
+package gmikit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// JsonLine is a line of gemtext as represented in JSON. Type is one of
+// "text", "link", "heading", "list", "quote" or "pre". A whole preformatted
+// block is a single "pre" line, with its lines in Lines, and Unterminated set
+// if the document ended before the block was closed.
+type JsonLine struct {
+	Type         string   `json:"type"`
+	Text         string   `json:"text,omitempty"`
+	URL          string   `json:"url,omitempty"`
+	Label        string   `json:"label,omitempty"`
+	Level        int      `json:"level,omitempty"`
+	Alt          string   `json:"alt,omitempty"`
+	Lines        []string `json:"lines,omitempty"`
+	Unterminated bool     `json:"unterminated,omitempty"`
+}
+
+// JsonWriter writes gemtext as a JSON array of JsonLine objects, one per
+// line.
+type JsonWriter struct {
+	w     io.Writer
+	first bool
+	pre   *JsonLine
+	lines []string
+}
+
+func NewJsonWriter(w io.Writer) *JsonWriter {
+	return &JsonWriter{w: w}
+}
+
+func (j *JsonWriter) write(line JsonLine) error {
+	b, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if j.first {
+		sep = "\n"
+		j.first = false
+	}
+	_, err = fmt.Fprintf(j.w, "%s  %s", sep, b)
+	return err
+}
+
+func (j *JsonWriter) Begin() error {
+	j.first = true
+	j.pre = nil
+	_, err := io.WriteString(j.w, "[")
+	return err
+}
+
+func (j *JsonWriter) End() error {
+	if j.pre != nil {
+		j.pre.Unterminated = true
+		if err := j.PreformattingToggle(""); err != nil {
+			return err
+		}
+	}
+	end := "\n]\n"
+	if j.first {
+		end = "]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+func (j *JsonWriter) Text(text string) error {
+	return j.write(JsonLine{Type: "text", Text: text})
+}
+
+func (j *JsonWriter) Link(target *url.URL, friendlyName string) error {
+	return j.MalformedLink(target.String(), friendlyName, nil)
+}
+
+func (j *JsonWriter) MalformedLink(target string, friendlyName string, _ error) error {
+	return j.write(JsonLine{Type: "link", URL: target, Label: friendlyName})
+}
+
+func (j *JsonWriter) PreformattingToggle(altText string) error {
+	if j.pre == nil {
+		j.pre = &JsonLine{Type: "pre", Alt: altText}
+		j.lines = nil
+		return nil
+	}
+	line := *j.pre
+	line.Lines = j.lines
+	j.pre = nil
+	return j.write(line)
+}
+
+func (j *JsonWriter) PreformattedText(text string) error {
+	j.lines = append(j.lines, text)
+	return nil
+}
+
+func (j *JsonWriter) Heading1(text string) error {
+	return j.write(JsonLine{Type: "heading", Text: text, Level: 1})
+}
+
+func (j *JsonWriter) Heading2(text string) error {
+	return j.write(JsonLine{Type: "heading", Text: text, Level: 2})
+}
+
+func (j *JsonWriter) Heading3(text string) error {
+	return j.write(JsonLine{Type: "heading", Text: text, Level: 3})
+}
+
+func (j *JsonWriter) UnorderedListItem(text string) error {
+	return j.write(JsonLine{Type: "list", Text: text})
+}
+
+func (j *JsonWriter) Quote(text string) error {
+	return j.write(JsonLine{Type: "quote", Text: text})
+}
+
+// Visit passes the line on to v. Links whose URL can't be parsed are passed
+// on as a MalformedLinkLine would be. Text and preformatted lines are escaped
+// if they would otherwise be read back as another type of line.
+func (l JsonLine) Visit(v Visitor) error {
+	switch l.Type {
+	case "text":
+		return v.Text(escapeText(l.Text))
+	case "link":
+		target, err := url.Parse(l.URL)
+		if err != nil {
+			line := MalformedLinkLine{Target: l.URL, FriendlyName: l.Label, Err: err}
+			return line.Visit(v)
+		}
+		return v.Link(target, l.Label)
+	case "heading":
+		switch {
+		case l.Level <= 1:
+			return v.Heading1(l.Text)
+		case l.Level == 2:
+			return v.Heading2(l.Text)
+		default:
+			return v.Heading3(l.Text)
+		}
+	case "list":
+		return v.UnorderedListItem(l.Text)
+	case "quote":
+		return v.Quote(l.Text)
+	case "pre":
+		if err := v.PreformattingToggle(l.Alt); err != nil {
+			return err
+		}
+		for _, text := range l.Lines {
+			if err := v.PreformattedText(escapePreformatted(text)); err != nil {
+				return err
+			}
+		}
+		if l.Unterminated {
+			return nil
+		}
+		return v.PreformattingToggle("")
+	default:
+		return fmt.Errorf("unknown line type \"%s\"", l.Type)
+	}
+}
+
+// JsonToGmi reads a JSON array of JsonLine objects, as written by a
+// JsonWriter, and passes the lines to v.
+func JsonToGmi(r io.Reader, v Visitor) error {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('[') {
+		return fmt.Errorf("expected an array of lines, got %v", tok)
+	}
+
+	if err := v.Begin(); err != nil {
+		return err
+	}
+	for n := 1; dec.More(); n++ {
+		var line JsonLine
+		if err := dec.Decode(&line); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		if err := line.Visit(v); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	return v.End()
+}
@@ -0,0 +1,132 @@
+package gmikit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJsonWriter(t *testing.T) {
+	input := strings.NewReader("# Title\n" +
+		"Text\n" +
+		"=> gemini://example.org/ Example\n" +
+		"```alt\n" +
+		"a\n" +
+		"b\n" +
+		"```\n" +
+		"* item\n")
+
+	expected := `[
+  {"type":"heading","text":"Title","level":1},
+  {"type":"text","text":"Text"},
+  {"type":"link","url":"gemini://example.org/","label":"Example"},
+  {"type":"pre","alt":"alt","lines":["a","b"]},
+  {"type":"list","text":"item"}
+]
+`
+
+	var output strings.Builder
+	if err := ParseLines(input, NewJsonWriter(&output)); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestJsonRoundtrip(t *testing.T) {
+	expected := "# One\n" +
+		"## Two\n" +
+		"### Three\n" +
+		"\n" +
+		"=> foo.gmi\n" +
+		"=> %zz Broken\n" +
+		"> Quote\n" +
+		"```\n" +
+		"```\n" +
+		"```\n" +
+		"\n" +
+		"```\n" +
+		"```x\n" +
+		"  pre\n" +
+		"```\n"
+
+	var encoded strings.Builder
+	parser := Parser{Lenient: true}
+	if err := parser.ParseLines(strings.NewReader(expected), NewJsonWriter(&encoded)); err != nil {
+		t.Fatal(err)
+	}
+
+	var output strings.Builder
+	if err := JsonToGmi(strings.NewReader(encoded.String()), NewGmiWriter(&output)); err != nil {
+		t.Fatal(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestJsonEmpty(t *testing.T) {
+	var output strings.Builder
+	if err := ParseLines(strings.NewReader(""), NewJsonWriter(&output)); err != nil {
+		t.Error(err)
+	}
+	if output.String() != "[]\n" {
+		t.Errorf("Expected empty array got %v", output.String())
+	}
+
+	if err := JsonToGmi(strings.NewReader(`[{"type":"bogus"}]`), NewGmiWriter(&output)); err == nil {
+		t.Error("Expected error for unknown line type")
+	}
+}
+
+func TestJsonRoundtripUnterminated(t *testing.T) {
+	expected := "# Title\n" +
+		"```alt\n" +
+		"never closed\n"
+
+	var encoded strings.Builder
+	if err := ParseLines(strings.NewReader(expected), NewJsonWriter(&encoded)); err != nil {
+		t.Fatal(err)
+	}
+
+	var output strings.Builder
+	if err := JsonToGmi(strings.NewReader(encoded.String()), NewGmiWriter(&output)); err != nil {
+		t.Fatal(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %q got %q", expected, actual)
+	}
+}
+
+func TestJsonToGmiEscapes(t *testing.T) {
+	input := `[
+  {"type":"text","text":"=> x"},
+  {"type":"list","text":"=> y"},
+  {"type":"pre","lines":["` + "```" + `","# z"]},
+  {"type":"text","text":"after"}
+]`
+
+	expected := " => x\n" +
+		"* => y\n" +
+		"```\n" +
+		" ```\n" +
+		"# z\n" +
+		"```\n" +
+		"after\n"
+
+	var output strings.Builder
+	if err := JsonToGmi(strings.NewReader(input), NewGmiWriter(&output)); err != nil {
+		t.Fatal(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %q got %q", expected, actual)
+	}
+}
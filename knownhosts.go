@@ -0,0 +1,302 @@
+package gmikit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A KnownHost is the fingerprint of the certificate trusted for a host.
+// Host includes the port, unless it is the default. Expires is when the
+// certificate expires, or the zero time if that isn't known.
+type KnownHost struct {
+	Host        string
+	Algorithm   string
+	Fingerprint string
+	Expires     time.Time
+}
+
+// ErrCertificateMismatch is returned when a host presents a certificate other
+// than the one it is known by, which hasn't expired yet.
+type ErrCertificateMismatch struct {
+	Host string
+	Old  string
+	New  string
+}
+
+func (e *ErrCertificateMismatch) Error() string {
+	return fmt.Sprintf("gemini: certificate for %s has changed from %s to %s",
+		e.Host, e.Old, e.New)
+}
+
+// ErrCertificateExpired is returned when a host presents a certificate which
+// has expired, and the policy is to reject them.
+type ErrCertificateExpired struct {
+	Host    string
+	Expired time.Time
+}
+
+func (e *ErrCertificateExpired) Error() string {
+	return fmt.Sprintf("gemini: certificate for %s expired at %v",
+		e.Host, e.Expired)
+}
+
+// ExpiredPolicy decides what KnownHosts does with expired certificates.
+type ExpiredPolicy int
+
+const (
+	// ExpiredReject rejects certificates which have expired, with an
+	// *ErrCertificateExpired.
+	ExpiredReject ExpiredPolicy = iota
+	// ExpiredAccept trusts expired certificates as it would any other.
+	ExpiredAccept
+	// ExpiredAcceptKnown trusts expired certificates only if they are the
+	// ones already known for the host.
+	ExpiredAcceptKnown
+)
+
+// KnownHosts is a trust-on-first-use store of certificate fingerprints. The
+// first certificate seen for a host is trusted from then on, until it
+// expires and may be replaced by a new one. Hosts are saved to a file in the
+// format other Gemini clients use, one per line:
+//
+//	example.org SHA-256 0A:1B:...:FF 1672531200
+//
+// where the last field is when the certificate expires, in seconds since the
+// Unix epoch. KnownHosts is safe to use from several goroutines.
+type KnownHosts struct {
+	Expired ExpiredPolicy
+	path    string
+	hosts   map[string]KnownHost
+	mu      sync.Mutex
+}
+
+// NewKnownHosts makes an empty store which is never saved.
+func NewKnownHosts() *KnownHosts {
+	return &KnownHosts{hosts: make(map[string]KnownHost)}
+}
+
+// LoadKnownHosts reads a known hosts file, which doesn't have to exist yet.
+// Hosts trusted later are saved back to the same file.
+func LoadKnownHosts(path string) (*KnownHosts, error) {
+	k := NewKnownHosts()
+	k.path = path
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return k, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := k.Read(f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return k, nil
+}
+
+// Read adds the hosts listed in r, replacing any already known.
+func (k *KnownHosts) Read(r io.Reader) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 3 {
+			return fmt.Errorf("line %d: expected host, algorithm and fingerprint", line)
+		}
+
+		host := KnownHost{
+			Host:        knownHostKey(fields[0]),
+			Algorithm:   fields[1],
+			Fingerprint: fields[2],
+		}
+		if len(fields) > 3 {
+			expires, err := strconv.ParseInt(fields[3], 10, 64)
+			if err != nil {
+				return fmt.Errorf("line %d: bad expiry: %w", line, err)
+			}
+			host.Expires = time.Unix(expires, 0)
+		}
+		k.hosts[host.Host] = host
+	}
+	return scanner.Err()
+}
+
+// Write lists the known hosts to w, sorted by host.
+func (k *KnownHosts) Write(w io.Writer) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.write(w)
+}
+
+func (k *KnownHosts) write(w io.Writer) error {
+	keys := make([]string, 0, len(k.hosts))
+	for key := range k.hosts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		host := k.hosts[key]
+		line := fmt.Sprintf("%s %s %s", host.Host, host.Algorithm, host.Fingerprint)
+		if !host.Expires.IsZero() {
+			line += " " + strconv.FormatInt(host.Expires.Unix(), 10)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save replaces the file the hosts were loaded from, if any.
+func (k *KnownHosts) save() error {
+	if k.path == "" {
+		return nil
+	}
+
+	dir := filepath.Dir(k.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".known_hosts")
+	if err != nil {
+		return err
+	}
+	if err := k.write(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), k.path)
+}
+
+// Lookup finds what is known about a host, which may include a port.
+func (k *KnownHosts) Lookup(host string) (KnownHost, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	known, ok := k.hosts[knownHostKey(host)]
+	return known, ok
+}
+
+// Add trusts cert for host from now on, and saves the change.
+func (k *KnownHosts) Add(host string, cert *x509.Certificate) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.add(host, cert)
+}
+
+func (k *KnownHosts) add(host string, cert *x509.Certificate) error {
+	key := knownHostKey(host)
+	k.hosts[key] = KnownHost{
+		Host:        key,
+		Algorithm:   "SHA-256",
+		Fingerprint: Fingerprint(cert),
+		Expires:     cert.NotAfter,
+	}
+	return k.save()
+}
+
+// TrustCertificate checks cert against the one known for hostname, trusting
+// it if the host isn't known yet or the known certificate has expired. It can
+// be used as a Client's TrustCertificate.
+func (k *KnownHosts) TrustCertificate(hostname string, cert *x509.Certificate) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	known, ok := k.hosts[knownHostKey(hostname)]
+	matches := ok && known.matches(cert)
+
+	if now.After(cert.NotAfter) {
+		switch {
+		case k.Expired == ExpiredAccept:
+		case k.Expired == ExpiredAcceptKnown && matches:
+		default:
+			return &ErrCertificateExpired{Host: hostname, Expired: cert.NotAfter}
+		}
+	}
+
+	if matches {
+		return nil
+	}
+	if ok && (known.Expires.IsZero() || now.Before(known.Expires)) {
+		return &ErrCertificateMismatch{
+			Host: hostname,
+			Old:  known.Fingerprint,
+			New:  fingerprint(known.Algorithm, cert),
+		}
+	}
+	return k.add(hostname, cert)
+}
+
+func (h KnownHost) matches(cert *x509.Certificate) bool {
+	actual := fingerprint(h.Algorithm, cert)
+	return actual != "" && normalFingerprint(actual) == normalFingerprint(h.Fingerprint)
+}
+
+// Fingerprint is the SHA-256 fingerprint of a certificate, in the format used
+// in known hosts files.
+func Fingerprint(cert *x509.Certificate) string {
+	return fingerprint("SHA-256", cert)
+}
+
+func fingerprint(algorithm string, cert *x509.Certificate) string {
+	var sum []byte
+	switch strings.ToUpper(algorithm) {
+	case "SHA-256", "SHA256":
+		s := sha256.Sum256(cert.Raw)
+		sum = s[:]
+	case "SHA-512", "SHA512":
+		s := sha512.Sum512(cert.Raw)
+		sum = s[:]
+	default:
+		return ""
+	}
+
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = strings.ToUpper(hex.EncodeToString([]byte{b}))
+	}
+	return strings.Join(parts, ":")
+}
+
+func normalFingerprint(fingerprint string) string {
+	return strings.ToUpper(strings.Replace(fingerprint, ":", "", -1))
+}
+
+// knownHostKey writes a host the way it is stored, lower-cased and without
+// the default port.
+func knownHostKey(host string) string {
+	host = strings.ToLower(host)
+	if h, port, err := net.SplitHostPort(host); err == nil && port == "1965" {
+		if strings.Contains(h, ":") {
+			return "[" + h + "]"
+		}
+		return h
+	}
+	return host
+}
@@ -0,0 +1,153 @@
+package gmikit
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T, notAfter time.Time) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.org"},
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestKnownHostsTrust(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gmikit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "known_hosts")
+
+	hosts, err := LoadKnownHosts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := testCertificate(t, time.Now().Add(time.Hour))
+	if err := hosts.TrustCertificate("example.org:1965", first); err != nil {
+		t.Errorf("first certificate: %v", err)
+	}
+	if err := hosts.TrustCertificate("example.org:1965", first); err != nil {
+		t.Errorf("same certificate: %v", err)
+	}
+	if err := hosts.TrustCertificate("example.org:1966", testCertificate(t, time.Now().Add(time.Hour))); err != nil {
+		t.Errorf("other port: %v", err)
+	}
+
+	second := testCertificate(t, time.Now().Add(time.Hour))
+	err = hosts.TrustCertificate("example.org:1965", second)
+	var mismatch *ErrCertificateMismatch
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Expected mismatch got %v", err)
+	}
+	if mismatch.Old != Fingerprint(first) || mismatch.New != Fingerprint(second) {
+		t.Errorf("Expected %s to %s got %s to %s",
+			Fingerprint(first), Fingerprint(second), mismatch.Old, mismatch.New)
+	}
+
+	saved, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(saved)), "\n")
+	expected := "example.org SHA-256 " + Fingerprint(first)
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], expected+" ") {
+		t.Errorf("Expected %s first of two lines got %q", expected, lines)
+	}
+
+	reloaded, err := LoadKnownHosts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	known, ok := reloaded.Lookup("EXAMPLE.org:1965")
+	if !ok || known.Fingerprint != Fingerprint(first) || known.Expires.Unix() != first.NotAfter.Unix() {
+		t.Errorf("Expected %s got %v", Fingerprint(first), known)
+	}
+}
+
+func TestKnownHostsExpired(t *testing.T) {
+	expired := testCertificate(t, time.Now().Add(-time.Hour))
+	current := testCertificate(t, time.Now().Add(time.Hour))
+
+	hosts := NewKnownHosts()
+	var expiredErr *ErrCertificateExpired
+	if err := hosts.TrustCertificate("example.org", expired); !errors.As(err, &expiredErr) {
+		t.Errorf("Expected expired certificate to be rejected, got %v", err)
+	}
+
+	hosts.Expired = ExpiredAcceptKnown
+	if err := hosts.TrustCertificate("example.org", expired); err == nil {
+		t.Error("Expected unknown expired certificate to be rejected")
+	}
+
+	hosts.Expired = ExpiredAccept
+	if err := hosts.TrustCertificate("example.org", expired); err != nil {
+		t.Errorf("Expected expired certificate to be accepted, got %v", err)
+	}
+
+	hosts.Expired = ExpiredAcceptKnown
+	if err := hosts.TrustCertificate("example.org", expired); err != nil {
+		t.Errorf("Expected known expired certificate to be accepted, got %v", err)
+	}
+
+	// The known certificate has expired, so it can be replaced
+	if err := hosts.TrustCertificate("example.org", current); err != nil {
+		t.Errorf("Expected replacement to be accepted, got %v", err)
+	}
+	if known, _ := hosts.Lookup("example.org"); known.Fingerprint != Fingerprint(current) {
+		t.Errorf("Expected %s got %s", Fingerprint(current), known.Fingerprint)
+	}
+}
+
+func TestKnownHostsRead(t *testing.T) {
+	input := strings.NewReader(`# comment
+example.org SHA-256 ab:cd:ef
+[::1]:1966 SHA-512 01:02 1700000000
+`)
+	hosts := NewKnownHosts()
+	if err := hosts.Read(input); err != nil {
+		t.Fatal(err)
+	}
+
+	var output strings.Builder
+	if err := hosts.Write(&output); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[::1]:1966 SHA-512 01:02 1700000000
+example.org SHA-256 ab:cd:ef
+`
+	if actual := output.String(); expected != actual {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+
+	if err := hosts.Read(strings.NewReader("example.org\n")); err == nil {
+		t.Error("Expected error for missing fingerprint")
+	}
+}
@@ -0,0 +1,89 @@
+package gmikit
+
+import (
+	"io"
+	"net/url"
+	"strings"
+)
+
+// ExtractedLink is a link found by ExtractLinks. Raw is the target as it was
+// written, and Target is the target resolved against the base URL, which is
+// nil if Raw couldn't be parsed.
+type ExtractedLink struct {
+	Raw          string
+	Target       *url.URL
+	FriendlyName string
+	Line         int
+	Internal     bool
+}
+
+// ExtractLinks finds every link in a gemtext document. If base is nil, links
+// are left as they are, and only relative links are considered internal.
+func ExtractLinks(r io.Reader, base *url.URL) ([]ExtractedLink, error) {
+	e := &linkExtractor{base: base}
+	p := Parser{Lenient: true}
+	if err := p.ParseLines(r, e); err != nil {
+		return nil, err
+	}
+	return e.links, nil
+}
+
+// IsInternalURL reports whether target is on the same capsule as root. That
+// is, it's relative, or it's a gemini URL with the same host and port.
+func IsInternalURL(target *url.URL, root *url.URL) bool {
+	if !target.IsAbs() {
+		return true
+	}
+	if target.Scheme != "gemini" || root == nil {
+		return false
+	}
+	return strings.EqualFold(target.Hostname(), root.Hostname()) &&
+		geminiPort(target) == geminiPort(root)
+}
+
+func geminiPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	return "1965"
+}
+
+type linkExtractor struct {
+	BaseVisitor
+	base  *url.URL
+	pos   Position
+	links []ExtractedLink
+}
+
+func (e *linkExtractor) Position(pos Position) error {
+	e.pos = pos
+	return nil
+}
+
+func (e *linkExtractor) Link(target *url.URL, friendlyName string) error {
+	raw, _ := splitLink(strings.TrimPrefix(e.pos.Raw, bom))
+	if raw == "" {
+		raw = target.String()
+	}
+	link := ExtractedLink{
+		Raw:          raw,
+		Target:       target,
+		FriendlyName: friendlyName,
+		Line:         e.pos.Line,
+	}
+	if e.base != nil {
+		link.Target = e.base.ResolveReference(target)
+	}
+	link.Internal = IsInternalURL(link.Target, e.base)
+	e.links = append(e.links, link)
+	return nil
+}
+
+func (e *linkExtractor) MalformedLink(target string, friendlyName string, _ error) error {
+	e.links = append(e.links, ExtractedLink{
+		Raw:          target,
+		FriendlyName: friendlyName,
+		Line:         e.pos.Line,
+	})
+	return nil
+}
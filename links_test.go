@@ -0,0 +1,54 @@
+package gmikit
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestExtractLinks(t *testing.T) {
+	input := strings.NewReader(`# Links
+=> /about.gmi About
+=>../up.gmi
+=>	gemini://EXAMPLE.org:1965/x Same capsule
+=> gemini://other.org/ Elsewhere
+=> HTTPS://example.org/ Web
+=> %zz Broken
+`)
+	base, _ := url.Parse("gemini://example.org/dir/page.gmi")
+
+	links, err := ExtractLinks(input, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		raw      string
+		target   string
+		name     string
+		line     int
+		internal bool
+	}{
+		{"/about.gmi", "gemini://example.org/about.gmi", "About", 2, true},
+		{"../up.gmi", "gemini://example.org/up.gmi", "", 3, true},
+		{"gemini://EXAMPLE.org:1965/x", "gemini://EXAMPLE.org:1965/x", "Same capsule", 4, true},
+		{"gemini://other.org/", "gemini://other.org/", "Elsewhere", 5, false},
+		{"HTTPS://example.org/", "https://example.org/", "Web", 6, false},
+		{"%zz", "", "Broken", 7, false},
+	}
+	if len(links) != len(expected) {
+		t.Fatalf("Expected %d links got %d", len(expected), len(links))
+	}
+	for i, e := range expected {
+		l := links[i]
+		target := ""
+		if l.Target != nil {
+			target = l.Target.String()
+		}
+		if l.Raw != e.raw || target != e.target || l.FriendlyName != e.name ||
+			l.Line != e.line || l.Internal != e.internal {
+			t.Errorf("Expected %v got %v %v %v %v %v",
+				e, l.Raw, target, l.FriendlyName, l.Line, l.Internal)
+		}
+	}
+}
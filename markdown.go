@@ -0,0 +1,571 @@
+package gmikit
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// markdownLink is a link found inside a Markdown block, to be written as a
+// link line once the block is done.
+type markdownLink struct {
+	target string
+	label  string
+}
+
+type markdownImporter struct {
+	v      Visitor
+	source []byte
+	links  []markdownLink
+	first  bool
+}
+
+// MarkdownToGmi converts a Markdown document to gemtext, passing the lines to
+// v. Inline links and images become link lines following the paragraph, list
+// or quote they appear in. Nested lists are flattened, fenced code blocks
+// become preformatted text with the language as alt text, and tables are laid
+// out as preformatted text.
+func MarkdownToGmi(r io.Reader, v Visitor) error {
+	source, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	md := goldmark.New(goldmark.WithExtensions(extension.Table))
+	doc := md.Parser().Parse(text.NewReader(source))
+
+	m := &markdownImporter{v: v, source: source, first: true}
+	if err := v.Begin(); err != nil {
+		return err
+	}
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		if err := m.block(n); err != nil {
+			return err
+		}
+	}
+	return v.End()
+}
+
+func (m *markdownImporter) separate() error {
+	if m.first {
+		m.first = false
+		return nil
+	}
+	return m.v.Text("")
+}
+
+func (m *markdownImporter) block(n ast.Node) error {
+	switch n := n.(type) {
+	case *ast.Heading:
+		if err := m.separate(); err != nil {
+			return err
+		}
+		text := strings.Join(m.inlineLines(n), " ")
+		var err error
+		switch n.Level {
+		case 1:
+			err = m.v.Heading1(text)
+		case 2:
+			err = m.v.Heading2(text)
+		default:
+			err = m.v.Heading3(text)
+		}
+		if err != nil {
+			return err
+		}
+	case *ast.Paragraph, *ast.TextBlock:
+		if err := m.separate(); err != nil {
+			return err
+		}
+		for _, line := range m.inlineLines(n) {
+			if err := m.v.Text(escapeText(line)); err != nil {
+				return err
+			}
+		}
+	case *ast.List:
+		if err := m.separate(); err != nil {
+			return err
+		}
+		if err := m.list(n); err != nil {
+			return err
+		}
+	case *ast.Blockquote:
+		if err := m.separate(); err != nil {
+			return err
+		}
+		if err := m.quote(n); err != nil {
+			return err
+		}
+	case *ast.FencedCodeBlock:
+		if err := m.separate(); err != nil {
+			return err
+		}
+		return m.code(string(n.Language(m.source)), n.Lines())
+	case *ast.CodeBlock:
+		if err := m.separate(); err != nil {
+			return err
+		}
+		return m.code("", n.Lines())
+	case *east.Table:
+		if err := m.separate(); err != nil {
+			return err
+		}
+		return m.table(n)
+	case *ast.ThematicBreak:
+		if err := m.separate(); err != nil {
+			return err
+		}
+		return m.v.Text("---")
+	default:
+		// Raw HTML and anything else without a gemtext equivalent is dropped
+		return nil
+	}
+	return m.flushLinks()
+}
+
+func (m *markdownImporter) flushLinks() error {
+	links := m.links
+	m.links = nil
+	for _, link := range links {
+		target, err := url.Parse(link.target)
+		if err != nil {
+			line := MalformedLinkLine{
+				Target:       link.target,
+				FriendlyName: link.label,
+				Err:          err,
+			}
+			if err := line.Visit(m.v); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := m.v.Link(target, link.label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *markdownImporter) list(list *ast.List) error {
+	number := list.Start
+	for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+		prefix := ""
+		if list.IsOrdered() {
+			prefix = strconv.Itoa(number) + ". "
+			number++
+		}
+
+		wrote := false
+		for n := item.FirstChild(); n != nil; n = n.NextSibling() {
+			switch n := n.(type) {
+			case *ast.List:
+				if err := m.list(n); err != nil {
+					return err
+				}
+			case *ast.Paragraph, *ast.TextBlock:
+				text := prefix + strings.Join(m.inlineLines(n), " ")
+				if err := m.v.UnorderedListItem(text); err != nil {
+					return err
+				}
+				prefix = ""
+				wrote = true
+			default:
+				// Code blocks and quotes inside list items are written as
+				// continuation items of their text
+				lines := m.plainLines(n)
+				for _, line := range lines {
+					if err := m.v.UnorderedListItem(prefix + line); err != nil {
+						return err
+					}
+					prefix = ""
+					wrote = true
+				}
+			}
+		}
+		if !wrote && prefix != "" {
+			if err := m.v.UnorderedListItem(strings.TrimSpace(prefix)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *markdownImporter) quote(quote ast.Node) error {
+	first := true
+	for n := quote.FirstChild(); n != nil; n = n.NextSibling() {
+		if !first {
+			if err := m.v.Quote(""); err != nil {
+				return err
+			}
+		}
+		first = false
+
+		var lines []string
+		switch n := n.(type) {
+		case *ast.Blockquote:
+			if err := m.quote(n); err != nil {
+				return err
+			}
+			continue
+		case *ast.Paragraph, *ast.TextBlock, *ast.Heading:
+			lines = m.inlineLines(n)
+		default:
+			lines = m.plainLines(n)
+		}
+		for _, line := range lines {
+			if err := m.v.Quote(line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// plainLines flattens any block into lines of text, for places gemtext can't
+// nest it.
+func (m *markdownImporter) plainLines(n ast.Node) []string {
+	switch n := n.(type) {
+	case *ast.Paragraph, *ast.TextBlock, *ast.Heading:
+		return m.inlineLines(n)
+	case *ast.FencedCodeBlock, *ast.CodeBlock:
+		return m.rawLines(n.Lines())
+	}
+	var lines []string
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		lines = append(lines, m.plainLines(c)...)
+	}
+	return lines
+}
+
+func (m *markdownImporter) rawLines(segments *text.Segments) []string {
+	lines := make([]string, segments.Len())
+	for i := range lines {
+		segment := segments.At(i)
+		lines[i] = strings.TrimRight(string(segment.Value(m.source)), "\r\n")
+	}
+	return lines
+}
+
+func (m *markdownImporter) code(lang string, segments *text.Segments) error {
+	if err := m.v.PreformattingToggle(lang); err != nil {
+		return err
+	}
+	for _, line := range m.rawLines(segments) {
+		if err := m.v.PreformattedText(escapePreformatted(line)); err != nil {
+			return err
+		}
+	}
+	return m.v.PreformattingToggle("")
+}
+
+func (m *markdownImporter) table(table *east.Table) error {
+	var rows [][]string
+	var widths []int
+	for row := table.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			text := strings.Join(m.inlineLines(cell), " ")
+			if len(cells) == len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(text); n > widths[len(cells)] {
+				widths[len(cells)] = n
+			}
+			cells = append(cells, text)
+		}
+		rows = append(rows, cells)
+	}
+
+	format := func(cells []string) string {
+		var b strings.Builder
+		for i, cell := range cells {
+			if i > 0 {
+				b.WriteString(" | ")
+			}
+			b.WriteString(cell)
+			if i < len(cells)-1 {
+				pad := widths[i] - utf8.RuneCountInString(cell)
+				b.WriteString(strings.Repeat(" ", pad))
+			}
+		}
+		return b.String()
+	}
+
+	if err := m.v.PreformattingToggle("table"); err != nil {
+		return err
+	}
+	for i, cells := range rows {
+		if err := m.v.PreformattedText(escapePreformatted(format(cells))); err != nil {
+			return err
+		}
+		if _, ok := table.FirstChild().(*east.TableHeader); ok && i == 0 {
+			rule := make([]string, len(widths))
+			for j, w := range widths {
+				rule[j] = strings.Repeat("-", w)
+			}
+			if err := m.v.PreformattedText(strings.Join(rule, "-+-")); err != nil {
+				return err
+			}
+		}
+	}
+	if err := m.v.PreformattingToggle(""); err != nil {
+		return err
+	}
+	return m.flushLinks()
+}
+
+// inlineLines flattens the inline content of n into text, split at hard line
+// breaks, and records any links it contains.
+func (m *markdownImporter) inlineLines(n ast.Node) []string {
+	var b strings.Builder
+	m.inline(n, &b)
+	lines := strings.Split(b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return lines
+}
+
+func (m *markdownImporter) inline(n ast.Node, b *strings.Builder) {
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		switch c := c.(type) {
+		case *ast.Text:
+			value := c.Segment.Value(m.source)
+			value = util.UnescapePunctuations(value)
+			value = util.ResolveNumericReferences(value)
+			b.Write(util.ResolveEntityNames(value))
+			if c.HardLineBreak() {
+				b.WriteByte('\n')
+			} else if c.SoftLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(c.Value)
+		case *ast.CodeSpan:
+			// Backslashes and entities in code are meant literally
+			b.WriteByte('`')
+			for t := c.FirstChild(); t != nil; t = t.NextSibling() {
+				if t, ok := t.(*ast.Text); ok {
+					b.Write(t.Segment.Value(m.source))
+				}
+			}
+			b.WriteByte('`')
+		case *ast.Link:
+			var label strings.Builder
+			m.inline(c, &label)
+			b.WriteString(label.String())
+			m.links = append(m.links, markdownLink{
+				target: string(c.Destination),
+				label:  strings.TrimSpace(label.String()),
+			})
+		case *ast.Image:
+			var label strings.Builder
+			m.inline(c, &label)
+			b.WriteString(label.String())
+			m.links = append(m.links, markdownLink{
+				target: string(c.Destination),
+				label:  strings.TrimSpace(label.String()),
+			})
+		case *ast.AutoLink:
+			target := string(c.URL(m.source))
+			b.WriteString(string(c.Label(m.source)))
+			m.links = append(m.links, markdownLink{target: target})
+		case *ast.RawHTML:
+			// Inline HTML has no meaning in gemtext
+		default:
+			m.inline(c, b)
+		}
+	}
+}
+
+// MarkdownWriter writes gemtext as CommonMark. Text is escaped so that it
+// reads the same in Markdown, and preformatted text becomes fenced code with
+// the alt text as its info string. Links are written as paragraphs of their
+// own, or with LinkList set, consecutive links are gathered into a list.
+type MarkdownWriter struct {
+	w        io.Writer
+	LinkList bool
+	last     string
+	pre      bool
+	alt      string
+	preLines []string
+}
+
+func NewMarkdownWriter(w io.Writer) *MarkdownWriter {
+	return &MarkdownWriter{w: w}
+}
+
+// block writes lines as a block of the given kind. Blocks are separated by a
+// blank line, unless they are of a kind which continues, in which case sep is
+// written between them instead.
+func (m *MarkdownWriter) block(kind string, continues bool, sep string, lines ...string) error {
+	if m.last != "" {
+		if continues && m.last == kind {
+			if _, err := io.WriteString(m.w, sep); err != nil {
+				return err
+			}
+		} else if _, err := io.WriteString(m.w, "\n"); err != nil {
+			return err
+		}
+	}
+	m.last = kind
+	for _, line := range lines {
+		if _, err := io.WriteString(m.w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MarkdownWriter) Begin() error {
+	m.last = ""
+	m.pre = false
+	return nil
+}
+
+func (m *MarkdownWriter) End() error {
+	if m.pre {
+		return m.PreformattingToggle("")
+	}
+	return nil
+}
+
+func (m *MarkdownWriter) Text(text string) error {
+	if text == "" {
+		// Paragraphs are already separated by blank lines
+		return nil
+	}
+	return m.block("text", false, "", markdownEscape(text, true))
+}
+
+func (m *MarkdownWriter) Link(target *url.URL, friendlyName string) error {
+	dest := markdownDestination(target.String())
+	var link string
+	if friendlyName == "" && target.IsAbs() {
+		link = "<" + dest + ">"
+	} else if friendlyName == "" {
+		link = fmt.Sprintf("[%s](%s)", markdownEscape(target.String(), false), dest)
+	} else {
+		link = fmt.Sprintf("[%s](%s)", markdownEscape(friendlyName, false), dest)
+	}
+
+	if m.LinkList {
+		return m.block("link", true, "", "- "+link)
+	}
+	return m.block("link", false, "", link)
+}
+
+func (m *MarkdownWriter) PreformattingToggle(altText string) error {
+	if !m.pre {
+		m.pre = true
+		m.alt = strings.TrimSpace(altText)
+		m.preLines = nil
+		return nil
+	}
+	m.pre = false
+
+	// The fence has to be longer than any fence-like line inside the block,
+	// and info strings can't contain backticks.
+	mark := "`"
+	if strings.Contains(m.alt, "`") {
+		mark = "~"
+	}
+	length := 3
+	for _, line := range m.preLines {
+		trimmed := strings.TrimLeft(line, " ")
+		n := len(trimmed) - len(strings.TrimLeft(trimmed, mark))
+		if n >= length {
+			length = n + 1
+		}
+	}
+	fence := strings.Repeat(mark, length)
+
+	lines := append([]string{fence + m.alt}, m.preLines...)
+	lines = append(lines, fence)
+	m.preLines = nil
+	return m.block("pre", false, "", lines...)
+}
+
+func (m *MarkdownWriter) PreformattedText(text string) error {
+	m.preLines = append(m.preLines, text)
+	return nil
+}
+
+func (m *MarkdownWriter) Heading1(text string) error {
+	return m.block("heading", false, "", "# "+markdownEscape(text, false))
+}
+
+func (m *MarkdownWriter) Heading2(text string) error {
+	return m.block("heading", false, "", "## "+markdownEscape(text, false))
+}
+
+func (m *MarkdownWriter) Heading3(text string) error {
+	return m.block("heading", false, "", "### "+markdownEscape(text, false))
+}
+
+func (m *MarkdownWriter) UnorderedListItem(text string) error {
+	return m.block("list", true, "", "- "+markdownEscape(text, true))
+}
+
+func (m *MarkdownWriter) Quote(text string) error {
+	if text == "" {
+		return nil
+	}
+	return m.block("quote", true, ">\n", "> "+markdownEscape(text, true))
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+	`<`, `\<`, `>`, `\>`, `#`, `\#`, `|`, `\|`, `~`, `\~`, `!`, `\!`,
+	`&`, `\&`,
+)
+
+// markdownEscape escapes text so Markdown reads it literally. With block set,
+// the text starts a line of its own, so anything that would start a list,
+// heading or thematic break there is escaped as well.
+func markdownEscape(text string, block bool) string {
+	text = markdownEscaper.Replace(text)
+	if !block {
+		return text
+	}
+
+	trimmed := strings.TrimLeft(text, " ")
+	indent := text[:len(text)-len(trimmed)]
+	if len(indent) >= 4 {
+		// Indented code
+		return "&#32;" + text[1:]
+	}
+	if trimmed == "" {
+		return text
+	}
+	switch trimmed[0] {
+	case '-', '+', '=':
+		return indent + `\` + trimmed
+	}
+	digits := len(trimmed) - len(strings.TrimLeft(trimmed, "0123456789"))
+	if digits > 0 && digits < len(trimmed) &&
+		(trimmed[digits] == '.' || trimmed[digits] == ')') {
+		return indent + trimmed[:digits] + `\` + trimmed[digits:]
+	}
+	return text
+}
+
+var markdownDestinationEscaper = strings.NewReplacer(
+	"(", "%28", ")", "%29", " ", "%20", "<", "%3C", ">", "%3E",
+)
+
+func markdownDestination(target string) string {
+	return markdownDestinationEscaper.Replace(target)
+}
@@ -0,0 +1,164 @@
+package gmikit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkdownToGmi(t *testing.T) {
+	input := strings.NewReader("# Title\n\n" +
+		"Some *emphasised* text with [a link](https://example.org/)\n" +
+		"and ![an image](cat.png).\n\n" +
+		"#### Deep heading\n\n" +
+		"- one\n" +
+		"  - nested\n" +
+		"- two <gemini://example.org/>\n\n" +
+		"3. three\n" +
+		"4. four\n\n" +
+		"> quoted\n\n" +
+		"```go\n" +
+		"fmt.Println(\"hi\")\n" +
+		"```\n\n" +
+		"| a | bb |\n" +
+		"|---|----|\n" +
+		"| ccc | d |\n")
+
+	expected := "# Title\n" +
+		"\n" +
+		"Some emphasised text with a link and an image.\n" +
+		"=> https://example.org/ a link\n" +
+		"=> cat.png an image\n" +
+		"\n" +
+		"### Deep heading\n" +
+		"\n" +
+		"* one\n" +
+		"* nested\n" +
+		"* two gemini://example.org/\n" +
+		"=> gemini://example.org/\n" +
+		"\n" +
+		"* 3. three\n" +
+		"* 4. four\n" +
+		"\n" +
+		"> quoted\n" +
+		"\n" +
+		"```go\n" +
+		"fmt.Println(\"hi\")\n" +
+		"```\n" +
+		"\n" +
+		"```table\n" +
+		"a   | bb\n" +
+		"----+---\n" +
+		"ccc | d\n" +
+		"```\n"
+
+	var output strings.Builder
+	if err := MarkdownToGmi(input, NewGmiWriter(&output)); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestMarkdownToGmiPlainText(t *testing.T) {
+	input := strings.NewReader("\\*literal\\* &amp; &#65; `\\*code&amp;`\n\n" +
+		"\\* x\n\n" +
+		"=> y\n\n" +
+		"\\# z\n")
+
+	expected := " *literal* & A `\\*code&amp;`\n" +
+		"\n" +
+		" * x\n" +
+		"\n" +
+		" => y\n" +
+		"\n" +
+		" # z\n"
+
+	var output strings.Builder
+	if err := MarkdownToGmi(input, NewGmiWriter(&output)); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %q got %q", expected, actual)
+	}
+}
+
+func TestMarkdownToGmiNestedFence(t *testing.T) {
+	input := strings.NewReader("~~~\n```\ninside\n```\n~~~\nafter\n")
+
+	expected := "```\n" +
+		" ```\n" +
+		"inside\n" +
+		" ```\n" +
+		"```\n" +
+		"\n" +
+		"after\n"
+
+	var output strings.Builder
+	if err := MarkdownToGmi(input, NewGmiWriter(&output)); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %q got %q", expected, actual)
+	}
+}
+
+func TestMarkdownWriter(t *testing.T) {
+	input := strings.NewReader("# A *title*\n" +
+		"Some text_with_underscores and [brackets]\n" +
+		"a &amp; b\n" +
+		"- not a list\n" +
+		"\n" +
+		"1. not a number\n" +
+		"=> gemini://example.org/ Example\n" +
+		"=> gemini://example.org/other\n" +
+		"* item\n" +
+		"* item two\n" +
+		"> quote\n" +
+		"> more\n" +
+		"```sh\n" +
+		"echo ```\n" +
+		"```\n")
+
+	expected := "# A \\*title\\*\n" +
+		"\n" +
+		"Some text\\_with\\_underscores and \\[brackets\\]\n" +
+		"\n" +
+		"a \\&amp; b\n" +
+		"\n" +
+		"\\- not a list\n" +
+		"\n" +
+		"1\\. not a number\n" +
+		"\n" +
+		"- [Example](gemini://example.org/)\n" +
+		"- <gemini://example.org/other>\n" +
+		"\n" +
+		"- item\n" +
+		"- item two\n" +
+		"\n" +
+		"> quote\n" +
+		">\n" +
+		"> more\n" +
+		"\n" +
+		"```sh\n" +
+		"echo ```\n" +
+		"```\n"
+
+	var output strings.Builder
+	m := NewMarkdownWriter(&output)
+	m.LinkList = true
+	if err := ParseLines(input, m); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
@@ -0,0 +1,140 @@
+package gmikit
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// An Outline is the tree of headings in a document.
+type Outline struct {
+	Headings []*OutlineHeading
+}
+
+// Len counts all of the headings in the outline.
+func (o *Outline) Len() int {
+	var count func(headings []*OutlineHeading) int
+	count = func(headings []*OutlineHeading) int {
+		n := len(headings)
+		for _, h := range headings {
+			n += count(h.Children)
+		}
+		return n
+	}
+	return count(o.Headings)
+}
+
+// OutlineHeading is a heading along with the lower level headings which
+// follow it. ID is the same anchor an HtmlWriter with HeadingIDs set gives
+// the heading, and Line is where it was found, if known.
+type OutlineHeading struct {
+	Level    int
+	Text     string
+	ID       string
+	Line     int
+	Children []*OutlineHeading
+}
+
+// OutlineBuilder is a Visitor which builds an Outline of what it visits.
+type OutlineBuilder struct {
+	BaseVisitor
+	Outline *Outline
+	slugs   Slugger
+	stack   []*OutlineHeading
+	line    int
+}
+
+func NewOutlineBuilder() *OutlineBuilder {
+	return &OutlineBuilder{Outline: &Outline{}}
+}
+
+func ParseOutline(r io.Reader) (*Outline, error) {
+	b := NewOutlineBuilder()
+	if err := ParseLines(r, b); err != nil {
+		return nil, err
+	}
+	return b.Outline, nil
+}
+
+func (doc *Document) Outline() *Outline {
+	b := NewOutlineBuilder()
+	// The builder never fails, and Walk only fails if the visitor does
+	_ = Walk(doc, b)
+	return b.Outline
+}
+
+func (b *OutlineBuilder) Position(pos Position) error {
+	b.line = pos.Line
+	return nil
+}
+
+func (b *OutlineBuilder) heading(level int, text string) error {
+	heading := &OutlineHeading{
+		Level: level,
+		Text:  text,
+		ID:    b.slugs.Slug(text),
+		Line:  b.line,
+	}
+	b.line = 0
+
+	for len(b.stack) > 0 && b.stack[len(b.stack)-1].Level >= level {
+		b.stack = b.stack[:len(b.stack)-1]
+	}
+	if len(b.stack) == 0 {
+		b.Outline.Headings = append(b.Outline.Headings, heading)
+	} else {
+		parent := b.stack[len(b.stack)-1]
+		parent.Children = append(parent.Children, heading)
+	}
+	b.stack = append(b.stack, heading)
+	return nil
+}
+
+func (b *OutlineBuilder) Heading1(text string) error { return b.heading(1, text) }
+func (b *OutlineBuilder) Heading2(text string) error { return b.heading(2, text) }
+func (b *OutlineBuilder) Heading3(text string) error { return b.heading(3, text) }
+
+// Slugify turns heading text into something suitable for a URL fragment, by
+// lower-casing it and replacing runs of anything other than letters and
+// digits with a dash.
+func Slugify(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// Slugger hands out slugs which are unique within a document, by numbering
+// repeats of the same slug. The zero value is ready to use.
+type Slugger struct {
+	seen map[string]int
+}
+
+func (s *Slugger) Slug(text string) string {
+	if s.seen == nil {
+		s.seen = make(map[string]int)
+	}
+
+	base := Slugify(text)
+	if base == "" {
+		base = "section"
+	}
+	slug := base
+	for s.seen[slug] > 0 {
+		s.seen[base]++
+		slug = base + "-" + strconv.Itoa(s.seen[base])
+	}
+	s.seen[slug]++
+	return slug
+}
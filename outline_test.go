@@ -0,0 +1,49 @@
+package gmikit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOutline(t *testing.T) {
+	input := strings.NewReader(`## Preamble
+# Title
+## Part one
+### Detail
+### Detail
+## Part two!
+Text
+### Ünïcode & more
+`)
+
+	outline, err := ParseOutline(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual strings.Builder
+	var dump func(headings []*OutlineHeading, depth int)
+	dump = func(headings []*OutlineHeading, depth int) {
+		for _, h := range headings {
+			actual.WriteString(strings.Repeat("  ", depth))
+			actual.WriteString(h.ID + " " + h.Text + "\n")
+			dump(h.Children, depth+1)
+		}
+	}
+	dump(outline.Headings, 0)
+
+	expected := `preamble Preamble
+title Title
+  part-one Part one
+    detail Detail
+    detail-2 Detail
+  part-two Part two!
+    ünïcode-more Ünïcode & more
+`
+	if expected != actual.String() {
+		t.Errorf("Expected %v got %v", expected, actual.String())
+	}
+	if line := outline.Headings[1].Children[1].Line; line != 6 {
+		t.Errorf("Expected line 6 got %d", line)
+	}
+}
@@ -0,0 +1,152 @@
+package gmikit
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"unicode/utf8"
+)
+
+// lineSplitter is a bufio.SplitFunc that enforces the parser's line length
+// limit and keeps track of where each token came from.
+type lineSplitter struct {
+	max    int
+	policy LongLinePolicy
+
+	// Describes the last token returned
+	line      int
+	start     int64
+	newline   string
+	continued bool
+
+	offset int64
+	split  bool
+	held   []byte
+}
+
+func (p *Parser) newScanner(r io.Reader) (*bufio.Scanner, *lineSplitter) {
+	s := &lineSplitter{max: p.MaxLineLength, policy: p.LongLines}
+	if s.max == 0 {
+		s.max = DefaultMaxLineLength
+	}
+
+	bufMax := int(^uint(0) >> 1)
+	if s.max > 0 {
+		// Room for the line ending, so we can tell when we're past the limit
+		bufMax = s.max + 2
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), bufMax)
+	scanner.Split(s.Split)
+	return scanner, s
+}
+
+func (s *lineSplitter) Split(data []byte, atEOF bool) (int, []byte, error) {
+	if s.held != nil {
+		return s.discard(data, atEOF)
+	}
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	end := bytes.IndexByte(data, '\n')
+	advance := end + 1
+	if end < 0 && atEOF {
+		end, advance = len(data), len(data)
+	}
+	if end >= 0 {
+		line, newline := splitNewline(data[:advance])
+		if s.max < 0 || len(line) <= s.max {
+			s.newline = newline
+			return s.token(advance, line, false)
+		}
+	} else if s.max < 0 || len(data) <= s.max+1 {
+		// Request more data
+		return 0, nil, nil
+	}
+
+	switch s.policy {
+	case LongLineTruncate:
+		cut := runeBoundary(data, s.max)
+		if end >= 0 {
+			_, s.newline = splitNewline(data[:advance])
+			return s.token(advance, data[:cut], false)
+		}
+		// Hold on to what we keep until we find the end of the line
+		s.held = append([]byte{}, data[:cut]...)
+		s.line++
+		s.start = s.offset
+		s.continued = false
+		s.offset += int64(len(data))
+		return len(data), nil, nil
+	case LongLineSplit:
+		cut := runeBoundary(data, s.max)
+		s.newline = ""
+		return s.token(cut, data[:cut], true)
+	default:
+		line := s.line
+		if !s.split {
+			line++
+		}
+		return 0, nil, &ErrLineTooLong{Line: line, Max: s.max}
+	}
+}
+
+func (s *lineSplitter) token(advance int, token []byte, more bool) (int, []byte, error) {
+	if !s.split {
+		s.line++
+	}
+	s.continued = s.split
+	s.split = more
+	s.start = s.offset
+	s.offset += int64(advance)
+	return advance, token, nil
+}
+
+func (s *lineSplitter) discard(data []byte, atEOF bool) (int, []byte, error) {
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 && !atEOF {
+		s.offset += int64(len(data))
+		return len(data), nil, nil
+	}
+
+	advance := end + 1
+	if end < 0 {
+		advance = len(data)
+	}
+	_, s.newline = splitNewline(data[:advance])
+	token := s.held
+	s.held = nil
+	s.offset += int64(advance)
+	return advance, token, nil
+}
+
+// splitNewline separates a line from its line ending, which may be missing at
+// the end of the input.
+func splitNewline(data []byte) ([]byte, string) {
+	newline := ""
+	if len(data) > 0 && data[len(data)-1] == '\n' {
+		data = data[:len(data)-1]
+		newline = "\n"
+	}
+	if len(data) > 0 && data[len(data)-1] == '\r' {
+		data = data[:len(data)-1]
+		newline = "\r" + newline
+	}
+	return data, newline
+}
+
+// runeBoundary returns the largest n <= max such that data[:n] doesn't end in
+// the middle of a UTF-8 sequence.
+func runeBoundary(data []byte, max int) int {
+	n := max
+	for n > 0 && n > max-utf8.UTFMax && !utf8.RuneStart(data[n]) {
+		n--
+	}
+	if n == 0 {
+		// Can't fit even a single rune, so give up on being tidy
+		return max
+	}
+	return n
+}
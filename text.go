@@ -0,0 +1,208 @@
+package gmikit
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TextStyle holds the escape sequences a TextWriter uses to style each kind
+// of line. Empty strings leave lines unstyled.
+type TextStyle struct {
+	Heading1     string
+	Heading2     string
+	Heading3     string
+	Link         string
+	Footnote     string
+	Quote        string
+	ListItem     string
+	Preformatted string
+}
+
+const ansiReset = "\x1b[0m"
+
+var AnsiStyle = TextStyle{
+	Heading1:     "\x1b[1;4;36m",
+	Heading2:     "\x1b[1;36m",
+	Heading3:     "\x1b[36m",
+	Link:         "\x1b[34m",
+	Footnote:     "\x1b[2m",
+	Quote:        "\x1b[3;32m",
+	Preformatted: "\x1b[33m",
+}
+
+// TextWriter renders gemtext for reading as plain text, wrapping lines to
+// Width columns. Links are numbered, and their targets listed as footnotes at
+// the end of the document. If Style is set, lines are styled with its escape
+// sequences rather than plain text decorations.
+type TextWriter struct {
+	w         io.Writer
+	Width     int
+	Style     *TextStyle
+	footnotes []*url.URL
+}
+
+func NewTextWriter(w io.Writer, width int) *TextWriter {
+	return &TextWriter{w: w, Width: width}
+}
+
+func NewAnsiWriter(w io.Writer, width int) *TextWriter {
+	style := AnsiStyle
+	return &TextWriter{w: w, Width: width, Style: &style}
+}
+
+func (t *TextWriter) write(style string, lines ...string) error {
+	for _, line := range lines {
+		line = strings.Map(stripControl, line)
+		var err error
+		if style != "" && line != "" {
+			_, err = fmt.Fprintf(t.w, "%s%s%s\n", style, line, ansiReset)
+		} else {
+			_, err = fmt.Fprintf(t.w, "%s\n", line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stripControl drops control characters other than tab, so that a document
+// can't send escape sequences to the terminal.
+func stripControl(r rune) rune {
+	if r == '\t' || !unicode.IsControl(r) {
+		return r
+	}
+	return -1
+}
+
+func (t *TextWriter) style(f func(s *TextStyle) string) string {
+	if t.Style == nil {
+		return ""
+	}
+	return f(t.Style)
+}
+
+func (t *TextWriter) Begin() error {
+	t.footnotes = nil
+	return nil
+}
+
+func (t *TextWriter) End() error {
+	if len(t.footnotes) == 0 {
+		return nil
+	}
+
+	style := t.style(func(s *TextStyle) string { return s.Footnote })
+	if err := t.write("", ""); err != nil {
+		return err
+	}
+	for i, target := range t.footnotes {
+		ref := fmt.Sprintf("[%d] ", i+1)
+		indent := strings.Repeat(" ", len(ref))
+		lines := wrap(target.String(), t.Width, ref, indent)
+		if err := t.write(style, lines...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *TextWriter) Text(text string) error {
+	return t.write("", wrap(text, t.Width, "", "")...)
+}
+
+func (t *TextWriter) Link(target *url.URL, friendlyName string) error {
+	style := t.style(func(s *TextStyle) string { return s.Link })
+	if friendlyName == "" {
+		// The target is listed with the rest so the numbers stay in order
+		friendlyName = target.String()
+	}
+
+	t.footnotes = append(t.footnotes, target)
+	ref := fmt.Sprintf("[%d] ", len(t.footnotes))
+	indent := strings.Repeat(" ", len(ref))
+	return t.write(style, wrap(friendlyName, t.Width, ref, indent)...)
+}
+
+func (t *TextWriter) PreformattingToggle(altText string) error {
+	return nil
+}
+
+func (t *TextWriter) PreformattedText(text string) error {
+	style := t.style(func(s *TextStyle) string { return s.Preformatted })
+	return t.write(style, text)
+}
+
+func (t *TextWriter) heading(style string, underline string, text string) error {
+	lines := wrap(text, t.Width, "", "")
+	if t.Style == nil && underline != "" {
+		length := 0
+		for _, line := range lines {
+			if n := utf8.RuneCountInString(line); n > length {
+				length = n
+			}
+		}
+		lines = append(lines, strings.Repeat(underline, length))
+	}
+	return t.write(style, lines...)
+}
+
+func (t *TextWriter) Heading1(text string) error {
+	return t.heading(t.style(func(s *TextStyle) string { return s.Heading1 }), "=", text)
+}
+
+func (t *TextWriter) Heading2(text string) error {
+	return t.heading(t.style(func(s *TextStyle) string { return s.Heading2 }), "-", text)
+}
+
+func (t *TextWriter) Heading3(text string) error {
+	return t.heading(t.style(func(s *TextStyle) string { return s.Heading3 }), "", text)
+}
+
+func (t *TextWriter) UnorderedListItem(text string) error {
+	style := t.style(func(s *TextStyle) string { return s.ListItem })
+	return t.write(style, wrap(text, t.Width, "  * ", "    ")...)
+}
+
+func (t *TextWriter) Quote(text string) error {
+	style := t.style(func(s *TextStyle) string { return s.Quote })
+	return t.write(style, wrap(text, t.Width, "    ", "    ")...)
+}
+
+// wrap breaks text into lines no wider than width, not counting the prefixes
+// on the first and following lines. Words longer than a line are left whole.
+func wrap(text string, width int, first string, rest string) []string {
+	words := strings.Fields(text)
+	if width <= 0 || len(words) == 0 {
+		return []string{first + text}
+	}
+
+	var lines []string
+	var line strings.Builder
+	prefix := first
+	line.WriteString(prefix)
+	length := utf8.RuneCountInString(prefix)
+	start := length
+	for _, word := range words {
+		n := utf8.RuneCountInString(word)
+		if length > start && length+1+n > width {
+			lines = append(lines, line.String())
+			line.Reset()
+			prefix = rest
+			line.WriteString(prefix)
+			length = utf8.RuneCountInString(prefix)
+			start = length
+		}
+		if length > start {
+			line.WriteByte(' ')
+			length++
+		}
+		line.WriteString(word)
+		length += n
+	}
+	return append(lines, line.String())
+}
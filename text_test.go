@@ -0,0 +1,90 @@
+package gmikit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTextWriter(t *testing.T) {
+	input := strings.NewReader(`# Title
+The quick brown fox jumps over the lazy dog.
+
+=> gemini://example.org/ Example site
+=> other.gmi
+* A list item which is fairly long
+> A quote
+` + "```\n" +
+		"  pre  text  that is not wrapped\n" +
+		"```\n")
+
+	expected := `Title
+=====
+The quick brown fox jumps
+over the lazy dog.
+
+[1] Example site
+[2] other.gmi
+  * A list item which is
+    fairly long
+    A quote
+  pre  text  that is not wrapped
+
+[1] gemini://example.org/
+[2] other.gmi
+`
+
+	var output strings.Builder
+	if err := ParseLines(input, NewTextWriter(&output, 26)); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestAnsiWriter(t *testing.T) {
+	input := strings.NewReader("## Section\n=> a.gmi A\n=> b.gmi\n=> c.gmi C\n")
+
+	expected := "\x1b[1;36mSection\x1b[0m\n" +
+		"\x1b[34m[1] A\x1b[0m\n" +
+		"\x1b[34m[2] b.gmi\x1b[0m\n" +
+		"\x1b[34m[3] C\x1b[0m\n" +
+		"\n" +
+		"\x1b[2m[1] a.gmi\x1b[0m\n" +
+		"\x1b[2m[2] b.gmi\x1b[0m\n" +
+		"\x1b[2m[3] c.gmi\x1b[0m\n"
+
+	var output strings.Builder
+	if err := ParseLines(input, NewAnsiWriter(&output, 0)); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %q got %q", expected, actual)
+	}
+}
+
+func TestAnsiWriterStripsEscapes(t *testing.T) {
+	input := strings.NewReader("Hi\x1b]0;pwned\a there\x7f\n" +
+		"=> gemini://example.org/ Clear\x1b[2J\n" +
+		"```\n\tpre\u009b31m\n```\n")
+
+	expected := "Hi]0;pwned there\n" +
+		"\x1b[34m[1] Clear[2J\x1b[0m\n" +
+		"\x1b[33m\tpre31m\x1b[0m\n" +
+		"\n" +
+		"\x1b[2m[1] gemini://example.org/\x1b[0m\n"
+
+	var output strings.Builder
+	if err := ParseLines(input, NewAnsiWriter(&output, 0)); err != nil {
+		t.Error(err)
+	}
+
+	actual := output.String()
+	if expected != actual {
+		t.Errorf("Expected %q got %q", expected, actual)
+	}
+}
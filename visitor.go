@@ -0,0 +1,206 @@
+package gmikit
+
+import (
+	"net/url"
+)
+
+// BaseVisitor does nothing with any line. Embed it in visitors that only care
+// about a few kinds of line.
+type BaseVisitor struct{}
+
+func (BaseVisitor) Begin() error                                    { return nil }
+func (BaseVisitor) End() error                                      { return nil }
+func (BaseVisitor) Text(text string) error                          { return nil }
+func (BaseVisitor) Link(target *url.URL, friendlyName string) error { return nil }
+func (BaseVisitor) PreformattingToggle(altText string) error        { return nil }
+func (BaseVisitor) PreformattedText(text string) error              { return nil }
+func (BaseVisitor) Heading1(text string) error                      { return nil }
+func (BaseVisitor) Heading2(text string) error                      { return nil }
+func (BaseVisitor) Heading3(text string) error                      { return nil }
+func (BaseVisitor) UnorderedListItem(text string) error             { return nil }
+func (BaseVisitor) Quote(text string) error                         { return nil }
+
+// PreformattingToggleLine and PreformattedTextLine are the individual lines
+// of a PreformattedBlock, as seen by Filter and Map.
+type PreformattingToggleLine struct {
+	AltText string
+	Pos     Position
+}
+
+type PreformattedTextLine struct {
+	Text string
+	Pos  Position
+}
+
+func (l PreformattingToggleLine) Visit(v Visitor) error {
+	if err := visitPosition(v, l.Pos); err != nil {
+		return err
+	}
+	return v.PreformattingToggle(l.AltText)
+}
+
+func (l PreformattedTextLine) Visit(v Visitor) error {
+	if err := visitPosition(v, l.Pos); err != nil {
+		return err
+	}
+	return v.PreformattedText(l.Text)
+}
+
+type tee []Visitor
+
+// Tee passes everything it visits on to each of visitors in turn, stopping at
+// the first error.
+func Tee(visitors ...Visitor) Visitor {
+	return tee(visitors)
+}
+
+func (t tee) each(f func(v Visitor) error) error {
+	for _, v := range t {
+		if err := f(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t tee) Position(pos Position) error {
+	return t.each(func(v Visitor) error { return visitPosition(v, pos) })
+}
+
+func (t tee) Begin() error {
+	return t.each(func(v Visitor) error { return v.Begin() })
+}
+
+func (t tee) End() error {
+	return t.each(func(v Visitor) error { return v.End() })
+}
+
+func (t tee) Text(text string) error {
+	return t.each(func(v Visitor) error { return v.Text(text) })
+}
+
+func (t tee) Link(target *url.URL, friendlyName string) error {
+	return t.each(func(v Visitor) error { return v.Link(target, friendlyName) })
+}
+
+func (t tee) MalformedLink(target string, friendlyName string, err error) error {
+	line := MalformedLinkLine{Target: target, FriendlyName: friendlyName, Err: err}
+	return t.each(line.Visit)
+}
+
+func (t tee) PreformattingToggle(altText string) error {
+	return t.each(func(v Visitor) error { return v.PreformattingToggle(altText) })
+}
+
+func (t tee) PreformattedText(text string) error {
+	return t.each(func(v Visitor) error { return v.PreformattedText(text) })
+}
+
+func (t tee) Heading1(text string) error {
+	return t.each(func(v Visitor) error { return v.Heading1(text) })
+}
+
+func (t tee) Heading2(text string) error {
+	return t.each(func(v Visitor) error { return v.Heading2(text) })
+}
+
+func (t tee) Heading3(text string) error {
+	return t.each(func(v Visitor) error { return v.Heading3(text) })
+}
+
+func (t tee) UnorderedListItem(text string) error {
+	return t.each(func(v Visitor) error { return v.UnorderedListItem(text) })
+}
+
+func (t tee) Quote(text string) error {
+	return t.each(func(v Visitor) error { return v.Quote(text) })
+}
+
+type mapper struct {
+	v   Visitor
+	f   func(Line) Line
+	pos Position
+}
+
+// Map calls f with each line it visits and passes the line f returns on to v.
+// Lines are dropped if f returns nil. Headings are passed to f as a
+// HeadingLine, and preformatted text as a PreformattingToggleLine for each
+// toggle and a PreformattedTextLine for each line in between.
+func Map(v Visitor, f func(Line) Line) Visitor {
+	return &mapper{v: v, f: f}
+}
+
+// Filter passes on only the lines for which keep returns true.
+func Filter(v Visitor, keep func(Line) bool) Visitor {
+	return Map(v, func(line Line) Line {
+		if keep(line) {
+			return line
+		}
+		return nil
+	})
+}
+
+func (m *mapper) visit(line Line) error {
+	m.pos = Position{}
+	if line = m.f(line); line == nil {
+		return nil
+	}
+	return line.Visit(m.v)
+}
+
+func (m *mapper) Position(pos Position) error {
+	m.pos = pos
+	return nil
+}
+
+func (m *mapper) Begin() error { return m.v.Begin() }
+func (m *mapper) End() error   { return m.v.End() }
+
+func (m *mapper) Text(text string) error {
+	return m.visit(TextLine{Text: text, Pos: m.pos})
+}
+
+func (m *mapper) Link(target *url.URL, friendlyName string) error {
+	return m.visit(LinkLine{
+		Target:       target,
+		FriendlyName: friendlyName,
+		Pos:          m.pos,
+	})
+}
+
+func (m *mapper) MalformedLink(target string, friendlyName string, err error) error {
+	return m.visit(MalformedLinkLine{
+		Target:       target,
+		FriendlyName: friendlyName,
+		Err:          err,
+		Pos:          m.pos,
+	})
+}
+
+func (m *mapper) PreformattingToggle(altText string) error {
+	return m.visit(PreformattingToggleLine{AltText: altText, Pos: m.pos})
+}
+
+func (m *mapper) PreformattedText(text string) error {
+	return m.visit(PreformattedTextLine{Text: text, Pos: m.pos})
+}
+
+func (m *mapper) Heading1(text string) error {
+	return m.visit(HeadingLine{Level: 1, Text: text, Pos: m.pos})
+}
+
+func (m *mapper) Heading2(text string) error {
+	return m.visit(HeadingLine{Level: 2, Text: text, Pos: m.pos})
+}
+
+func (m *mapper) Heading3(text string) error {
+	return m.visit(HeadingLine{Level: 3, Text: text, Pos: m.pos})
+}
+
+func (m *mapper) UnorderedListItem(text string) error {
+	return m.visit(ListItemLine{Text: text, Pos: m.pos})
+}
+
+func (m *mapper) Quote(text string) error {
+	return m.visit(QuoteLine{Text: text, Pos: m.pos})
+}
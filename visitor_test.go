@@ -0,0 +1,62 @@
+package gmikit
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type linkCollector struct {
+	BaseVisitor
+	links []string
+}
+
+func (c *linkCollector) Link(target *url.URL, friendlyName string) error {
+	c.links = append(c.links, target.String())
+	return nil
+}
+
+func TestTee(t *testing.T) {
+	input := strings.NewReader("# Title\n=> a.gmi A\nText\n=> b.gmi\n")
+	links := &linkCollector{}
+	var output strings.Builder
+	if err := ParseLines(input, Tee(links, NewGmiWriter(&output))); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(links.links) != 2 || links.links[0] != "a.gmi" || links.links[1] != "b.gmi" {
+		t.Errorf("Expected [a.gmi b.gmi] got %v", links.links)
+	}
+	expected := "# Title\n=> a.gmi A\nText\n=> b.gmi\n"
+	if actual := output.String(); expected != actual {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestFilterMap(t *testing.T) {
+	input := strings.NewReader("# Title\n=> a.gmi A\n## Section\n```\npre\n```\n")
+	var output strings.Builder
+	v := Filter(NewGmiWriter(&output), func(line Line) bool {
+		_, isLink := line.(LinkLine)
+		return !isLink
+	})
+	v = Map(v, func(line Line) Line {
+		switch l := line.(type) {
+		case HeadingLine:
+			l.Level++
+			return l
+		case PreformattedTextLine:
+			return PreformattedTextLine{Text: strings.ToUpper(l.Text)}
+		default:
+			return line
+		}
+	})
+	if err := ParseLines(input, v); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "## Title\n### Section\n```\nPRE\n```\n"
+	if actual := output.String(); expected != actual {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}